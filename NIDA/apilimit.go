@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// RateLimitStore keeps a token bucket per key. The in-memory store suits a
+// single instance; instances behind a load balancer need a shared one.
+type RateLimitStore interface {
+	// Allow takes a token from key's bucket. When none is left it returns
+	// false and how long until one is.
+	Allow(ctx context.Context, key string, limit RateLimit) (bool, time.Duration, error)
+}
+
+// APIRateLimitConfig limits callers of our own API. A request has to fit
+// all of the limits that apply to it.
+type APIRateLimitConfig struct {
+	ClientIP  RateLimit `json:"client_ip"`
+	Principal RateLimit `json:"principal"`
+	// NIN limits requests about any one NIN, whoever makes them, so the
+	// service cannot be used to enumerate NINs.
+	NIN RateLimit `json:"nin"`
+}
+
+func (ac APIRateLimitConfig) Validate() error {
+	for name, rl := range map[string]RateLimit{"client_ip": ac.ClientIP, "principal": ac.Principal, "nin": ac.NIN} {
+		if err := rl.validate(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// principalKey is where Handlers.identify stores the caller's identity in
+// the gin context.
+const principalKey = "principal"
+
+// adminPrincipal is the identity of callers holding the admin token.
+const adminPrincipal = "admin"
+
+// maxPeekedBody bounds how much of a request body is read to find a NIN.
+const maxPeekedBody = 1 << 20
+
+// APILimiter rate limits API requests by client IP, authenticated
+// principal and target NIN.
+type APILimiter struct {
+	cfg     APIRateLimitConfig
+	store   RateLimitStore
+	hashNIN func(string) string
+}
+
+func NewAPILimiter(cfg APIRateLimitConfig, store RateLimitStore, hashNIN func(string) string) *APILimiter {
+	return &APILimiter{cfg: cfg, store: store, hashNIN: hashNIN}
+}
+
+// hashNIN keeps NINs out of rate limit and cache keys, which may live in a
+// shared store. It is an HMAC under nin_hash_key, as the NIN space is small
+// enough for a plain hash to be reversed by hashing every NIN.
+func (cfg *Config) hashNIN(nin string) string {
+	mac := hmac.New(sha256.New, []byte(cfg.NINHashKey))
+	mac.Write([]byte(nin))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// identify records the caller's identity under principalKey. Until API
+// clients authenticate, the only identity is that of the admin token.
+func (h *Handlers) identify(c *gin.Context) {
+	if h.isAdmin(c) {
+		c.Set(principalKey, adminPrincipal)
+	}
+	c.Next()
+}
+
+// Middleware limits by client IP and principal, and by NIN where the
+// request names one in the nin query parameter or a JSON body. Handlers
+// that learn the NIN later check it with AllowNIN.
+func (l *APILimiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !l.allow(c, "ip:"+c.ClientIP(), l.cfg.ClientIP) {
+			return
+		}
+
+		if principal := c.GetString(principalKey); principal != "" {
+			if !l.allow(c, "principal:"+principal, l.cfg.Principal) {
+				return
+			}
+		}
+
+		if nin := requestNIN(c); nin != "" && !l.AllowNIN(c, nin) {
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// AllowNIN applies the per-NIN limit. When it is exceeded it responds 429,
+// aborts c and returns false.
+func (l *APILimiter) AllowNIN(c *gin.Context, nin string) bool {
+	if l == nil {
+		return true
+	}
+	return l.allow(c, "nin:"+l.hashNIN(nin), l.cfg.NIN)
+}
+
+func (l *APILimiter) allow(c *gin.Context, key string, limit RateLimit) bool {
+	if limit.RPS == 0 {
+		return true
+	}
+
+	ok, retryAfter, err := l.store.Allow(c.Request.Context(), key, limit)
+	if err != nil {
+		// Failing open keeps the API up when a shared store is down.
+		loggerFrom(c.Request.Context()).Error("rate limit store", "err", err)
+		return true
+	}
+	if ok {
+		return true
+	}
+
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "too many requests"})
+	return false
+}
+
+// requestNIN finds the NIN a request is about, leaving the body for the
+// handler to read.
+func requestNIN(c *gin.Context) string {
+	if nin := c.Query("nin"); nin != "" {
+		return nin
+	}
+
+	if c.Request.Body == nil || c.ContentType() != gin.MIMEJSON {
+		return ""
+	}
+
+	bs, err := io.ReadAll(io.LimitReader(c.Request.Body, maxPeekedBody))
+	c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(bs), c.Request.Body))
+	if err != nil {
+		return ""
+	}
+
+	// Field matching is case-insensitive, so this finds NIN as well.
+	var body struct {
+		NIN string `json:"nin"`
+	}
+	if json.Unmarshal(bs, &body) != nil {
+		return ""
+	}
+
+	return body.NIN
+}
+
+// memoryRateLimitStore is a RateLimitStore for a single instance. Buckets
+// idle for longer than idleTTL are dropped.
+type memoryRateLimitStore struct {
+	idleTTL time.Duration
+
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+type memoryBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func NewMemoryRateLimitStore() RateLimitStore {
+	return &memoryRateLimitStore{idleTTL: 10 * time.Minute, buckets: map[string]*memoryBucket{}}
+}
+
+func (s *memoryRateLimitStore) Allow(_ context.Context, key string, limit RateLimit) (bool, time.Duration, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) > s.idleTTL {
+		for k, b := range s.buckets {
+			if now.Sub(b.lastSeen) > s.idleTTL {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{limiter: limit.limiter()}
+		s.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	r := b.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return false, delay, nil
+	}
+
+	return true, 0, nil
+}
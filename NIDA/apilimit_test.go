@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testAPIRouter serves GET /ping behind identify and an APILimiter, the
+// way run sets them up.
+func testAPIRouter(t *testing.T, cfg *Config) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	handlers := &Handlers{Config: cfg}
+	limiter := NewAPILimiter(cfg.APIRateLimit, NewMemoryRateLimitStore(), cfg.hashNIN)
+
+	router := gin.New()
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		t.Fatal(err)
+	}
+	router.GET("/ping", handlers.identify, limiter.Middleware(), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	return router
+}
+
+func ping(router http.Handler, remoteAddr string, header http.Header) int {
+	req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+	req.RemoteAddr = remoteAddr
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestAPILimiterClientIP(t *testing.T) {
+	limit := APIRateLimitConfig{ClientIP: RateLimit{RPS: 0.001, Burst: 1}}
+
+	t.Run("forwarded for is ignored by default", func(t *testing.T) {
+		router := testAPIRouter(t, &Config{APIRateLimit: limit})
+		for i, forwardedFor := range []string{"198.51.100.1", "198.51.100.2"} {
+			code := ping(router, "203.0.113.7:4000", http.Header{"X-Forwarded-For": {forwardedFor}})
+			if want := []int{http.StatusNoContent, http.StatusTooManyRequests}[i]; code != want {
+				t.Fatalf("request %d: status %d, want %d", i+1, code, want)
+			}
+		}
+	})
+
+	t.Run("forwarded for from a trusted proxy", func(t *testing.T) {
+		router := testAPIRouter(t, &Config{APIRateLimit: limit, Server: ServerConfig{TrustedProxies: []string{"203.0.113.0/24"}}})
+		for _, forwardedFor := range []string{"198.51.100.1", "198.51.100.2"} {
+			if code := ping(router, "203.0.113.7:4000", http.Header{"X-Forwarded-For": {forwardedFor}}); code != http.StatusNoContent {
+				t.Fatalf("client %s: status %d, want %d", forwardedFor, code, http.StatusNoContent)
+			}
+		}
+	})
+}
+
+func TestAPILimiterPrincipal(t *testing.T) {
+	token := "0123456789abcdef0123456789abcdef"
+	router := testAPIRouter(t, &Config{
+		AdminToken:   Secret(token),
+		APIRateLimit: APIRateLimitConfig{Principal: RateLimit{RPS: 0.001, Burst: 1}},
+	})
+
+	admin := http.Header{adminTokenHeader: {token}}
+	if code := ping(router, "203.0.113.7:4000", admin); code != http.StatusNoContent {
+		t.Fatalf("first admin request: status %d, want %d", code, http.StatusNoContent)
+	}
+	if code := ping(router, "203.0.113.8:4000", admin); code != http.StatusTooManyRequests {
+		t.Fatalf("second admin request from another IP: status %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if code := ping(router, "203.0.113.7:4000", nil); code != http.StatusNoContent {
+		t.Fatalf("anonymous request: status %d, want %d", code, http.StatusNoContent)
+	}
+}
+
+// TestAPILimiterNINFitsOnboarding sends the requests of one merchant's
+// onboarding under the per-NIN limit of conf.json. Every step names the
+// NIN once, in the body or through the merchant record: /register,
+// /verify/v2, an answer to each reverse question, allowing for wrong answers,
+// and the demographics, photo, fingerprint and name match checks.
+func TestAPILimiterNINFitsOnboarding(t *testing.T) {
+	const onboardingRequests = 1 + 1 + 10 + 4
+
+	bs, err := os.ReadFile("conf.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conf struct {
+		APIRateLimit APIRateLimitConfig `json:"api_rate_limit"`
+	}
+	if err := json.Unmarshal(bs, &conf); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	limiter := NewAPILimiter(APIRateLimitConfig{NIN: conf.APIRateLimit.NIN}, NewMemoryRateLimitStore(), (&Config{}).hashNIN)
+	router := gin.New()
+	router.POST("/step", limiter.Middleware(), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	step := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/step", strings.NewReader(`{"nin": "19900101123450000123"}`))
+		req.Header.Set("Content-Type", gin.MIMEJSON)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for i := range onboardingRequests {
+		if code := step(); code != http.StatusNoContent {
+			t.Fatalf("onboarding request %d: status %d, want %d", i+1, code, http.StatusNoContent)
+		}
+	}
+	for range conf.APIRateLimit.NIN.Burst {
+		if step() == http.StatusTooManyRequests {
+			return
+		}
+	}
+	t.Fatal("the per-NIN limit never applied")
+}
+
+func TestHashNIN(t *testing.T) {
+	nin := "19900101123450000123"
+	a := (&Config{NINHashKey: "key-a"}).hashNIN(nin)
+	b := (&Config{NINHashKey: "key-b"}).hashNIN(nin)
+	if a == b {
+		t.Fatal("hashNIN does not depend on nin_hash_key")
+	}
+	if a != (&Config{NINHashKey: "key-a"}).hashNIN(nin) {
+		t.Fatal("hashNIN is not deterministic")
+	}
+}
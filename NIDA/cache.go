@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VerificationOutcome is all that is cached of a verification: no names,
+// images or templates.
+type VerificationOutcome struct {
+	Verified      bool      `json:"verified"`
+	VerifiedAt    time.Time `json:"verified_at"`
+	TransactionID string    `json:"transaction_id"`
+}
+
+// VerificationCache remembers verification outcomes per NIN and operation.
+// NINs are passed hashed, see Config.hashNIN. Operations that depend on
+// more than the NIN carry it in the operation, see
+// Handlers.fingerprintCacheOperation.
+type VerificationCache interface {
+	Get(ctx context.Context, ninHash, operation string) (VerificationOutcome, bool, error)
+	Put(ctx context.Context, ninHash, operation string, outcome VerificationOutcome) error
+	// Invalidate forgets every outcome cached for the NIN.
+	Invalidate(ctx context.Context, ninHash string) error
+}
+
+// VerificationCacheConfig sets how long outcomes are reused.
+type VerificationCacheConfig struct {
+	TTL Duration `json:"ttl"`
+}
+
+func (vc VerificationCacheConfig) Validate() error {
+	if vc.TTL <= 0 {
+		return fmt.Errorf("verification_cache: ttl must be positive")
+	}
+	return nil
+}
+
+// adminTokenHeader carries the admin token on admin requests.
+const adminTokenHeader = "X-Admin-Token"
+
+// bypassCacheHeader lets an admin force a fresh gateway call.
+const bypassCacheHeader = "X-Bypass-Cache"
+
+// isAdmin reports whether the request carries the admin token. Admin access
+// is off while admin_token is unset.
+func (h *Handlers) isAdmin(c *gin.Context) bool {
+	token := string(h.Config.AdminToken)
+	if token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.GetHeader(adminTokenHeader)), []byte(token)) == 1
+}
+
+func (h *Handlers) adminOnly(c *gin.Context) {
+	if !h.isAdmin(c) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin token required"})
+		return
+	}
+	c.Next()
+}
+
+// bypassCache reports whether an admin asked for the cache to be skipped.
+func (h *Handlers) bypassCache(c *gin.Context) bool {
+	return c.GetHeader(bypassCacheHeader) != "" && h.isAdmin(c)
+}
+
+type cachedOutcome struct {
+	outcome VerificationOutcome
+	expires time.Time
+}
+
+// memoryVerificationCache is a VerificationCache for a single instance.
+type memoryVerificationCache struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]map[string]cachedOutcome
+	lastSweep time.Time
+}
+
+func NewMemoryVerificationCache(cfg VerificationCacheConfig) VerificationCache {
+	return &memoryVerificationCache{ttl: time.Duration(cfg.TTL), entries: map[string]map[string]cachedOutcome{}}
+}
+
+func (mc *memoryVerificationCache) Get(_ context.Context, ninHash, operation string) (VerificationOutcome, bool, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	entry, ok := mc.entries[ninHash][operation]
+	if !ok {
+		return VerificationOutcome{}, false, nil
+	}
+	if time.Now().After(entry.expires) {
+		delete(mc.entries[ninHash], operation)
+		if len(mc.entries[ninHash]) == 0 {
+			delete(mc.entries, ninHash)
+		}
+		return VerificationOutcome{}, false, nil
+	}
+
+	return entry.outcome, true, nil
+}
+
+func (mc *memoryVerificationCache) Put(_ context.Context, ninHash, operation string, outcome VerificationOutcome) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(mc.lastSweep) > mc.ttl {
+		for h, ops := range mc.entries {
+			for op, e := range ops {
+				if now.After(e.expires) {
+					delete(ops, op)
+				}
+			}
+			if len(ops) == 0 {
+				delete(mc.entries, h)
+			}
+		}
+		mc.lastSweep = now
+	}
+
+	if mc.entries[ninHash] == nil {
+		mc.entries[ninHash] = map[string]cachedOutcome{}
+	}
+	mc.entries[ninHash][operation] = cachedOutcome{outcome: outcome, expires: now.Add(mc.ttl)}
+
+	return nil
+}
+
+func (mc *memoryVerificationCache) Invalidate(_ context.Context, ninHash string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	delete(mc.entries, ninHash)
+	return nil
+}
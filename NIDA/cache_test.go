@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFingerprintCacheOperation(t *testing.T) {
+	h := &Handlers{Config: &Config{NINHashKey: "key"}}
+	base := fingerprintRequest{Finger: "R1", Format: "ISO19794-2", Template: []byte("template")}
+
+	others := map[string]fingerprintRequest{
+		"finger":   {Finger: "L1", Format: base.Format, Template: base.Template},
+		"format":   {Finger: base.Finger, Format: "ANSI378", Template: base.Template},
+		"template": {Finger: base.Finger, Format: base.Format, Template: []byte("another capture")},
+		"boundary": {Finger: "R1I", Format: "SO19794-2", Template: base.Template},
+	}
+	op := h.fingerprintCacheOperation(base)
+	if again := h.fingerprintCacheOperation(base); again != op {
+		t.Fatalf("the same capture gave %s and %s", op, again)
+	}
+	for name, other := range others {
+		if h.fingerprintCacheOperation(other) == op {
+			t.Errorf("a different %s shares the cache operation", name)
+		}
+	}
+}
+
+func TestVerificationCacheConfigValidate(t *testing.T) {
+	tests := []struct {
+		ttl     Duration
+		wantErr bool
+	}{
+		{Duration(24 * time.Hour), false},
+		{0, true},
+		{Duration(-time.Minute), true},
+	}
+	for _, tt := range tests {
+		err := VerificationCacheConfig{TTL: tt.ttl}.Validate()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Validate with ttl %v = %v, want error %v", time.Duration(tt.ttl), err, tt.wantErr)
+		}
+	}
+}
+
+func TestMemoryVerificationCache(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMemoryVerificationCache(VerificationCacheConfig{TTL: Duration(time.Hour)})
+	outcome := VerificationOutcome{Verified: true, TransactionID: "tx-1"}
+
+	if err := cache.Put(ctx, "nin", "op-a", outcome); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok, err := cache.Get(ctx, "nin", "op-a"); err != nil || !ok || got != outcome {
+		t.Fatalf("Get = %+v, %v, %v; want %+v", got, ok, err, outcome)
+	}
+	if _, ok, _ := cache.Get(ctx, "nin", "op-b"); ok {
+		t.Fatal("Get found an outcome for another operation")
+	}
+
+	if err := cache.Invalidate(ctx, "nin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := cache.Get(ctx, "nin", "op-a"); ok {
+		t.Fatal("Get found an outcome after Invalidate")
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"time"
+)
+
+const defaultCertExpiryWarningDays = 30
+
+// parseCertificate accepts a certificate in PEM or DER form, as NIDA has
+// shipped .cer files in both encodings.
+func parseCertificate(bs []byte) (*x509.Certificate, error) {
+	if block, _ := pem.Decode(bs); block != nil {
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("expected CERTIFICATE PEM block but got %s", block.Type)
+		}
+		bs = block.Bytes
+	}
+
+	cert, err := x509.ParseCertificate(bs)
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate: %w", err)
+	}
+
+	return cert, nil
+}
+
+func readCABundle(filename string) (*x509.CertPool, error) {
+	bs, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bs) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", filename)
+	}
+
+	return pool, nil
+}
+
+// validateCertificate checks that cert may be used for NIDA message
+// security: not expired, usable for key encipherment and signatures, and
+// issued by roots when a CA bundle is configured.
+func validateCertificate(cert *x509.Certificate, roots *x509.CertPool, now time.Time) error {
+	if !now.Before(cert.NotAfter) {
+		return fmt.Errorf("certificate %q expired on %s", cert.Subject.CommonName, cert.NotAfter.Format(time.RFC3339))
+	}
+
+	const usage = x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
+	if cert.KeyUsage != 0 && cert.KeyUsage&usage != usage {
+		return fmt.Errorf("certificate %q is not valid for key encipherment and digital signature", cert.Subject.CommonName)
+	}
+
+	if roots == nil {
+		return nil
+	}
+
+	// Check the chain as of the certificate's own validity window so that a
+	// certificate staged ahead of rotation still verifies.
+	verifyAt := now
+	if verifyAt.Before(cert.NotBefore) {
+		verifyAt = cert.NotBefore
+	}
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: verifyAt,
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("verify certificate %q: %w", cert.Subject.CommonName, err)
+	}
+
+	return nil
+}
+
+// validateMessageSecurityKeys validates every certificate behind keys,
+// narrows each key's validity window to its certificate's and, once the key
+// set is accepted, records when each certificate expires. Expired
+// certificates are dropped with a warning so that a rotated-out certificate
+// does not stop the keys loading, as long as another certificate remains.
+// A rejected key set leaves the recorded expiries of the current one.
+func validateMessageSecurityKeys(keys KeyProvider, roots *x509.CertPool, warningDays int) (KeyProvider, error) {
+	privKeys, err := keys.StakeholderKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	pubKeys, err := keys.MessageSecurityKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	validated := make([]MessageSecurityKey, 0, len(pubKeys))
+	var expired error
+	for _, k := range pubKeys {
+		if k.Certificate == nil {
+			validated = append(validated, k)
+			continue
+		}
+
+		err := validateCertificate(k.Certificate, roots, now)
+		if err != nil && !now.Before(k.Certificate.NotAfter) {
+			slog.Warn("skipping expired message security certificate", "key_id", k.ID, "not_after", k.Certificate.NotAfter.Format(time.RFC3339))
+			if expired == nil {
+				expired = fmt.Errorf("message security key %s: %w", k.ID, err)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("message security key %s: %w", k.ID, err)
+		}
+
+		if k.NotBefore.Before(k.Certificate.NotBefore) {
+			k.NotBefore = k.Certificate.NotBefore
+		}
+		if k.NotAfter.IsZero() || k.NotAfter.After(k.Certificate.NotAfter) {
+			k.NotAfter = k.Certificate.NotAfter
+		}
+
+		daysLeft := k.Certificate.NotAfter.Sub(now).Hours() / 24
+		if daysLeft < float64(warningDays) {
+			slog.Warn("message security certificate expires soon", "key_id", k.ID, "days_left", math.Floor(daysLeft), "not_after", k.Certificate.NotAfter.Format(time.RFC3339))
+		}
+
+		validated = append(validated, k)
+	}
+	if len(validated) == 0 && expired != nil {
+		return nil, expired
+	}
+
+	certificateExpiryTimestamp.Reset()
+	for _, k := range validated {
+		if k.Certificate != nil {
+			certificateExpiryTimestamp.WithLabelValues(k.ID).Set(float64(k.Certificate.NotAfter.Unix()))
+		}
+	}
+
+	return &staticKeyProvider{privKeys: privKeys, pubKeys: validated}, nil
+}
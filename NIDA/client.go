@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+//go:generate go run ./wsdl/wsdlgen -wsdl wsdl/GatewayService.wsdl -payloads wsdl/payloads.xsd -out gateway_gen.go
+
+// NidaClient sends encrypted envelopes to the CIG gateway.
+type NidaClient struct {
+	cfg     *Config
+	http    *http.Client
+	breaker *circuitBreaker
+	limiter *gatewayLimiter
+}
+
+func NewNidaClient(cfg *Config) (*NidaClient, error) {
+	httpClient, err := newHTTPClient(cfg.HTTP)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NidaClient{
+		cfg:     cfg,
+		http:    httpClient,
+		breaker: newCircuitBreaker(cfg.Breaker),
+		limiter: newGatewayLimiter(cfg.RateLimit),
+	}, nil
+}
+
+// call sends payload to the gateway as operation and returns the verified,
+// decrypted response payload. The request is abandoned when ctx is done.
+// Every attempt waits its turn under the rate limits. Idempotent operations
+// are retried on gateway failures, and no request is sent while the circuit
+// breaker is open. The response header is returned alongside the payload.
+func (c *NidaClient) call(ctx context.Context, operation string, payload any) (_ []byte, _ SoapHeader, err error) {
+	ctx, span := tracer.Start(ctx, "gateway "+operation)
+	defer func() { endSpan(span, err) }()
+
+	attempts := 1
+	if idempotentOperations[operation] {
+		attempts = c.cfg.Retry.MaxAttempts
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err := c.limiter.wait(ctx, operation); err != nil {
+			countGatewayCall(operation, 0, err)
+			return nil, SoapHeader{}, err
+		}
+
+		if err := c.breaker.allow(time.Now()); err != nil {
+			countGatewayCall(operation, 0, err)
+			return nil, SoapHeader{}, err
+		}
+
+		resp, header, err := c.roundTrip(ctx, operation, payload)
+		c.breaker.record(ctx, time.Now(), err)
+		if err == nil || attempt >= attempts || !isGatewayFailure(ctx, err) {
+			return resp, header, err
+		}
+
+		if err := sleepContext(ctx, c.cfg.Retry.backoff(attempt)); err != nil {
+			return nil, SoapHeader{}, err
+		}
+	}
+}
+
+// roundTrip makes a single attempt at call. The exchange with the gateway
+// gets a span of its own, apart from the crypto steps around it.
+func (c *NidaClient) roundTrip(ctx context.Context, operation string, payload any) ([]byte, SoapHeader, error) {
+	req, err := newSoapRequest(ctx, c.cfg, "test", payload)
+	if err != nil {
+		return nil, SoapHeader{}, err
+	}
+
+	requestPayload, err := xml.MarshalIndent(req, "", "  ")
+	if err != nil {
+		return nil, SoapHeader{}, err
+	}
+
+	logger := loggerFrom(ctx).With("operation", operation)
+	logger.Debug("gateway request", "envelope_id", req.Header.Id, "bytes", len(requestPayload))
+	start := time.Now()
+
+	soapCtx, span := startSoapSpan(ctx, operation)
+	httpReq, err := newSoapHTTPRequest(soapCtx, c.cfg.NidaURL, c.cfg.SOAP, operation, bytes.NewReader(requestPayload))
+	if err != nil {
+		endSpan(span, err)
+		return nil, SoapHeader{}, err
+	}
+
+	resp, err := c.http.Do(httpReq)
+	if err != nil {
+		observeGatewayCall(operation, start, 0, err)
+		endSpan(span, err)
+		logger.Warn("gateway request failed", "err", err, "duration_ms", time.Since(start).Milliseconds())
+		return nil, SoapHeader{}, err
+	}
+	defer resp.Body.Close()
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+
+	// Parse the response
+	responseEnvelope, err := decodeSoapResponse(resp.Body)
+	logger.Debug("gateway response", "status", resp.StatusCode, "transaction_id", responseEnvelope.Header.Id, "duration_ms", time.Since(start).Milliseconds())
+	if err != nil {
+		var fault *SOAPFault
+		if !errors.As(err, &fault) && resp.StatusCode != http.StatusOK {
+			err = &GatewayStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+		}
+		observeGatewayCall(operation, start, resp.StatusCode, err)
+		endSpan(span, err)
+		return nil, SoapHeader{}, err
+	}
+	endSpan(span, nil)
+
+	payloadBytes, err := responseEnvelope.Payload(ctx, c.cfg)
+	observeGatewayCall(operation, start, resp.StatusCode, err)
+	return payloadBytes, responseEnvelope.Header, err
+}
+
+// gatewayResponse is embedded in every generated response type to carry
+// what the envelope says about the response.
+type gatewayResponse struct {
+	// TransactionID is the Id of the response header.
+	TransactionID string `xml:"-"`
+}
+
+func (r *gatewayResponse) setHeader(h SoapHeader) {
+	r.TransactionID = h.Id
+}
+
+// invoke calls operation with req and decodes the response payload into
+// resp. The typed operation methods in gateway_gen.go are built on it.
+func (c *NidaClient) invoke(ctx context.Context, operation string, req, resp any) error {
+	payload, header, err := c.call(ctx, operation, req)
+	if err != nil {
+		return err
+	}
+
+	if err := xml.Unmarshal(payload, resp); err != nil {
+		return fmt.Errorf("decode %s payload: %w", operation, err)
+	}
+
+	if r, ok := resp.(interface{ setHeader(SoapHeader) }); ok {
+		r.setHeader(header)
+	}
+
+	return nil
+}
+
+// RequestQuestion asks NIDA for the first reverse question for nin.
+func (c *NidaClient) RequestQuestion(ctx context.Context, nin string) (*RQVerificationResponse, error) {
+	return c.RQVerification(ctx, &RQVerificationRequest{NIN: nin})
+}
+
+// gatewayCodeOK is the Code of a response that answers the request. Any
+// other code, such as for an unknown NIN, comes without data.
+const gatewayCodeOK = 0
+
+// GatewayCodeError is a response whose Code says NIDA could not answer.
+type GatewayCodeError struct {
+	Operation string
+	Code      int
+}
+
+func (e *GatewayCodeError) Error() string {
+	return fmt.Sprintf("%s: NIDA answered code %d", e.Operation, e.Code)
+}
+
+// LookupDemographics fetches the demographic data registered for nin.
+func (c *NidaClient) LookupDemographics(ctx context.Context, nin string) (*Demographics, error) {
+	resp, err := c.DemographicLookup(ctx, &DemographicLookupRequest{NIN: nin})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Code != gatewayCodeOK {
+		return nil, &GatewayCodeError{Operation: opDemographicLookup, Code: resp.Code}
+	}
+
+	return &resp.Demographics, nil
+}
+
+// RetrievePhoto fetches the photo registered for nin.
+func (c *NidaClient) RetrievePhoto(ctx context.Context, nin string) (*Photo, error) {
+	resp, err := c.PhotoRetrieval(ctx, &PhotoRetrievalRequest{NIN: nin})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Code != gatewayCodeOK {
+		return nil, &GatewayCodeError{Operation: opPhotoRetrieval, Code: resp.Code}
+	}
+
+	return &resp.Photo, nil
+}
+
+// VerifyFingerprint matches a captured template of finger against the one
+// registered for nin.
+func (c *NidaClient) VerifyFingerprint(ctx context.Context, nin, finger, format string, template []byte) (*FingerprintVerificationResponse, error) {
+	resp, err := c.FingerprintVerification(ctx, &FingerprintVerificationRequest{
+		NIN:            nin,
+		FingerCode:     finger,
+		TemplateFormat: format,
+		Template:       template,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Matched is only meaningful when NIDA could compare the templates.
+	if resp.Code != gatewayCodeOK {
+		return nil, &GatewayCodeError{Operation: opFingerprintVerification, Code: resp.Code}
+	}
+
+	return resp, nil
+}
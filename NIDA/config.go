@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Secret is a config value that is masked when the config is printed or
+// logged. Use string(s) for the value itself.
+type Secret string
+
+const maskedSecret = "********"
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return maskedSecret
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// DatabaseConfig is the MySQL merchant store.
+type DatabaseConfig struct {
+	User     string `json:"user"`
+	Password Secret `json:"password"`
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Name     string `json:"name"`
+}
+
+func (dc DatabaseConfig) withDefaults() DatabaseConfig {
+	if dc.User == "" {
+		dc.User = "root"
+	}
+	if dc.Host == "" {
+		dc.Host = "127.0.0.1"
+	}
+	if dc.Port == 0 {
+		dc.Port = 3306
+	}
+	if dc.Name == "" {
+		dc.Name = "pesapal"
+	}
+	return dc
+}
+
+func (dc DatabaseConfig) mysql() mysql.Config {
+	return mysql.Config{
+		User:                 dc.User,
+		Passwd:               string(dc.Password),
+		Addr:                 net.JoinHostPort(dc.Host, strconv.Itoa(dc.Port)),
+		DBName:               dc.Name,
+		Net:                  "tcp",
+		AllowNativePasswords: true,
+		ParseTime:            true,
+	}
+}
+
+// JWTConfig configures the tokens issued to API clients.
+type JWTConfig struct {
+	Secret     Secret   `json:"secret"`
+	Expiration Duration `json:"expiration"`
+}
+
+func (jc JWTConfig) withDefaults() JWTConfig {
+	if jc.Expiration == 0 {
+		jc.Expiration = Duration(7 * 24 * time.Hour)
+	}
+	return jc
+}
+
+// minSecretLength is the shortest JWT secret, admin token or NIN hash key accepted
+// outside the development profiles.
+const minSecretLength = 32
+
+// legacyJWTSecret was the built-in default JWT secret.
+const legacyJWTSecret = "not-so-secret-now-is-it?"
+
+// insecure lists the settings that are only acceptable in development.
+func (rc rawConfig) insecure() []string {
+	var problems []string
+	switch {
+	case rc.JWT.Secret == "":
+		problems = append(problems, "jwt.secret is not set")
+	case rc.JWT.Secret == legacyJWTSecret:
+		problems = append(problems, "jwt.secret is the old built-in default")
+	case len(rc.JWT.Secret) < minSecretLength:
+		problems = append(problems, fmt.Sprintf("jwt.secret is shorter than %d characters", minSecretLength))
+	}
+	if rc.AdminToken != "" && len(rc.AdminToken) < minSecretLength {
+		problems = append(problems, fmt.Sprintf("admin_token is shorter than %d characters", minSecretLength))
+	}
+	switch {
+	case rc.NINHashKey == "":
+		problems = append(problems, "nin_hash_key is not set")
+	case len(rc.NINHashKey) < minSecretLength:
+		problems = append(problems, fmt.Sprintf("nin_hash_key is shorter than %d characters", minSecretLength))
+	}
+	if rc.Database.Password == "" {
+		problems = append(problems, "database.password is not set")
+	}
+	if !strings.HasPrefix(rc.NidaURL, "https://") {
+		problems = append(problems, "nida_url is not https")
+	}
+	return problems
+}
+
+// envSettings are the environment variables that override config settings.
+// Most keep the names they had before the config was unified.
+var envSettings = []struct {
+	env  string
+	path string
+	// value turns the variable into the setting's value. By default the
+	// variable is taken as a string.
+	value func(string) any
+}{
+	{env: "NIDA_PROFILE", path: "profile"},
+	{env: "NIDA_URL", path: "nida_url"},
+	{env: "NIDA_USER_ID", path: "user_id"},
+	{env: "PUBLIC_HOST", path: "server.public_host"},
+	{env: "PORT", path: "server.addr", value: func(v string) any { return ":" + v }},
+	{env: "DB_USER", path: "database.user"},
+	{env: "DB_PASSWORD", path: "database.password"},
+	{env: "DB_HOST", path: "database.host"},
+	{env: "DB_PORT", path: "database.port", value: flagValue},
+	{env: "DB_NAME", path: "database.name"},
+	{env: "JWT_SECRET", path: "jwt.secret"},
+	{env: "JWT_EXPIRATION_IN_SECONDS", path: "jwt.expiration", value: func(v string) any { return v + "s" }},
+	{env: "ADMIN_TOKEN", path: "admin_token"},
+	{env: "NIN_HASH_KEY", path: "nin_hash_key"},
+	{env: "LOG_LEVEL", path: "log.level"},
+}
+
+// configSource is where configuration comes from. Each layer overrides the
+// one before: built-in defaults, the shared settings of the config file,
+// the section of the active profile, the environment, then flags.
+type configSource struct {
+	file      string
+	lookupEnv func(string) (string, bool)
+	// sets are path=value overrides from flags, in command line order.
+	sets []string
+}
+
+// configFlags registers the config flags on fs. The file defaults to
+// $NIDA_CONFIG, then conf.json.
+func configFlags(fs *flag.FlagSet) *configSource {
+	file, ok := os.LookupEnv("NIDA_CONFIG")
+	if !ok {
+		file = "conf.json"
+	}
+	src := &configSource{file: file, lookupEnv: os.LookupEnv}
+
+	fs.StringVar(&src.file, "config", src.file, "config file (env NIDA_CONFIG)")
+	fs.Func("set", "override a setting as path=value, e.g. retry.max_attempts=5; repeatable", func(s string) error {
+		if !strings.Contains(s, "=") {
+			return fmt.Errorf("expected path=value")
+		}
+		src.sets = append(src.sets, s)
+		return nil
+	})
+	for name, path := range map[string]string{"profile": "profile", "addr": "server.addr", "log-level": "log.level"} {
+		fs.Func(name, "shorthand for -set "+path+"=value", func(s string) error {
+			src.sets = append(src.sets, path+"="+s)
+			return nil
+		})
+	}
+
+	return src
+}
+
+// load reads the settings from every layer of src, with defaults applied.
+// Unknown settings are an error, to catch typos.
+func (src *configSource) load() (rawConfig, error) {
+	tree := map[string]any{}
+	bs, err := os.ReadFile(src.file)
+	if err != nil {
+		return rawConfig{}, err
+	}
+	if err := json.Unmarshal(bs, &tree); err != nil {
+		return rawConfig{}, fmt.Errorf("%s: %w", src.file, err)
+	}
+
+	overrides := map[string]any{}
+	for _, s := range envSettings {
+		v, ok := src.lookupEnv(s.env)
+		if !ok {
+			continue
+		}
+		var value any = v
+		if s.value != nil {
+			value = s.value(v)
+		}
+		if err := setPath(overrides, s.path, value); err != nil {
+			return rawConfig{}, fmt.Errorf("%s: %w", s.env, err)
+		}
+	}
+
+	for _, s := range src.sets {
+		path, v, _ := strings.Cut(s, "=")
+		if err := setPath(overrides, path, flagValue(v)); err != nil {
+			return rawConfig{}, fmt.Errorf("-set %s: %w", path, err)
+		}
+	}
+
+	applyProfile(tree, overrides)
+	mergeTree(tree, overrides)
+
+	if bs, err = json.Marshal(tree); err != nil {
+		return rawConfig{}, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(bs))
+	dec.DisallowUnknownFields()
+
+	var rc rawConfig
+	if err := dec.Decode(&rc); err != nil {
+		return rawConfig{}, fmt.Errorf("config: %w", err)
+	}
+
+	return rc.withDefaults(), nil
+}
+
+// flagValue reads the value of a -set flag or variable as JSON, so that
+// numbers, bools and objects can be set, and as a plain string otherwise.
+func flagValue(v string) any {
+	var value any
+	if json.Unmarshal([]byte(v), &value) == nil {
+		return value
+	}
+	return v
+}
+
+// setPath sets the dotted path in tree, creating objects on the way.
+func setPath(tree map[string]any, path string, value any) error {
+	keys := strings.Split(path, ".")
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := tree[key]
+		if !ok {
+			next = map[string]any{}
+			tree[key] = next
+		}
+		obj, ok := next.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s is not an object", key)
+		}
+		tree = obj
+	}
+	tree[keys[len(keys)-1]] = value
+	return nil
+}
+
+const configUsage = `usage: nida config print [flags]
+
+Prints the effective configuration, after the config file, environment
+and flags are applied, with secrets masked. Exits non-zero if the
+configuration is invalid.
+`
+
+func nidactlConfig(args []string, stdout io.Writer) error {
+	if len(args) == 0 || args[0] != "print" {
+		return errors.New(configUsage)
+	}
+
+	fs := flag.NewFlagSet("config print", flag.ContinueOnError)
+	src := configFlags(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	rc, err := src.load()
+	if err != nil {
+		return err
+	}
+
+	bs, err := json.MarshalIndent(rc, "", "    ")
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(stdout, string(bs)); err != nil {
+		return err
+	}
+
+	return rc.Validate()
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const layeredTestConfig = `{
+    "profile": "staging",
+    "user_id": "FILE",
+    "log": {"level": "info"},
+    "verification_cache": {"ttl": "1h"},
+    "profiles": {
+        "sandbox": {"nida_url": "http://localhost:8090/GatewayService.svc", "user_id": "SANDBOX"},
+        "staging": {"nida_url": "https://test-cig.example/GatewayService.svc", "user_id": "STAGING"}
+    }
+}`
+
+func TestConfigSourcePrecedence(t *testing.T) {
+	file := writeTestConfig(t, layeredTestConfig)
+
+	tests := []struct {
+		name        string
+		env         map[string]string
+		sets        []string
+		wantProfile string
+		wantUserID  string
+		wantLevel   string
+	}{
+		{
+			name:        "profile over file",
+			wantProfile: profileStaging,
+			wantUserID:  "STAGING",
+			wantLevel:   "info",
+		},
+		{
+			name:        "env over profile",
+			env:         map[string]string{"NIDA_USER_ID": "ENV", "LOG_LEVEL": "debug"},
+			wantProfile: profileStaging,
+			wantUserID:  "ENV",
+			wantLevel:   "debug",
+		},
+		{
+			name:        "set over env",
+			env:         map[string]string{"NIDA_USER_ID": "ENV", "LOG_LEVEL": "debug"},
+			sets:        []string{"user_id=SET", "log.level=warn"},
+			wantProfile: profileStaging,
+			wantUserID:  "SET",
+			wantLevel:   "warn",
+		},
+		{
+			name:        "later set wins",
+			sets:        []string{"user_id=FIRST", "user_id=SECOND"},
+			wantProfile: profileStaging,
+			wantUserID:  "SECOND",
+			wantLevel:   "info",
+		},
+		{
+			name:        "env selects the profile",
+			env:         map[string]string{"NIDA_PROFILE": profileSandbox},
+			wantProfile: profileSandbox,
+			wantUserID:  "SANDBOX",
+			wantLevel:   "info",
+		},
+		{
+			name:        "set selects the profile over env",
+			env:         map[string]string{"NIDA_PROFILE": profileSandbox},
+			sets:        []string{"profile=" + profileStaging},
+			wantProfile: profileStaging,
+			wantUserID:  "STAGING",
+			wantLevel:   "info",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := &configSource{
+				file: file,
+				lookupEnv: func(name string) (string, bool) {
+					v, ok := tt.env[name]
+					return v, ok
+				},
+				sets: tt.sets,
+			}
+			rc, err := src.load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rc.Profile != tt.wantProfile {
+				t.Errorf("profile = %q, want %q", rc.Profile, tt.wantProfile)
+			}
+			if rc.UserID != tt.wantUserID {
+				t.Errorf("user_id = %q, want %q", rc.UserID, tt.wantUserID)
+			}
+			if rc.Log.Level != tt.wantLevel {
+				t.Errorf("log.level = %q, want %q", rc.Log.Level, tt.wantLevel)
+			}
+		})
+	}
+}
+
+func TestConfigSourceRejectsUnknownSettings(t *testing.T) {
+	src := &configSource{
+		file:      writeTestConfig(t, layeredTestConfig),
+		lookupEnv: func(string) (string, bool) { return "", false },
+		sets:      []string{"retry.max_atempts=5"},
+	}
+	if _, err := src.load(); err == nil || !strings.Contains(err.Error(), "max_atempts") {
+		t.Fatalf("load = %v, want an unknown field error", err)
+	}
+}
+
+func TestConfigRejectsInsecureDefaults(t *testing.T) {
+	file := writeTestConfig(t, layeredTestConfig)
+	secure := []string{
+		"jwt.secret=" + strings.Repeat("j", minSecretLength),
+		"nin_hash_key=" + strings.Repeat("n", minSecretLength),
+		"database.password=secret",
+	}
+
+	tests := []struct {
+		name    string
+		sets    []string
+		wantErr string
+	}{
+		{name: "secure staging", sets: secure},
+		{name: "insecure sandbox", sets: []string{"profile=" + profileSandbox}},
+		{name: "no jwt secret", sets: secure[1:], wantErr: "jwt.secret is not set"},
+		{name: "legacy jwt secret", sets: append([]string{"jwt.secret=" + legacyJWTSecret}, secure[1:]...), wantErr: "jwt.secret is the old built-in default"},
+		{name: "short admin token", sets: append([]string{"admin_token=short"}, secure...), wantErr: "admin_token is shorter than"},
+		{name: "short nin hash key", sets: append([]string{"nin_hash_key=short"}, secure[0], secure[2]), wantErr: "nin_hash_key is shorter than"},
+		{name: "no database password", sets: secure[:2], wantErr: "database.password is not set"},
+		{name: "plain http gateway", sets: append([]string{"nida_url=http://test-cig.example/GatewayService.svc"}, secure...), wantErr: "nida_url is not https"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := &configSource{
+				file:      file,
+				lookupEnv: func(string) (string, bool) { return "", false },
+				sets:      tt.sets,
+			}
+			rc, err := src.load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = rc.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -3,20 +3,17 @@ package configs
 import (
 	"fmt"
 	"os"
-	"strconv"
 
 	"github.com/joho/godotenv"
 )
 
+// Config holds the database settings for db/migrate. The service itself
+// reads them with the rest of its config, see LoadConfig in package main.
 type Config struct {
-	PublicHost		string
-	Port					string
 	DBUser					string	
 	DBPassword				string
 	DBAddress				string
 	DBName					string
-	JWTSecret				string
-	JWTExpirationInSeconds	int64
 }
 
 var Envs = initConfig()
@@ -25,14 +22,10 @@ func initConfig() Config {
 	godotenv.Load()
 
 	return Config{
-		PublicHost:             getEnv("PUBLIC_HOST", "http://localhost"),
-		Port:                   getEnv("PORT", "8080"),
 		DBUser:                 getEnv("DB_USER", "root"),
 		DBPassword:             getEnv("DB_PASSWORD", ""),
 		DBAddress:              fmt.Sprintf("%s:%s", getEnv("DB_HOST", "127.0.0.1"), getEnv("DB_PORT", "3306")),
 		DBName:                 getEnv("DB_NAME", "pesapal"),
-		JWTSecret:              getEnv("JWT_SECRET", "not-so-secret-now-is-it?"),
-		JWTExpirationInSeconds: getEnvAsInt("JWT_EXPIRATION_IN_SECONDS", 3600 * 24 * 7),
 	}
 }
 
@@ -41,18 +34,5 @@ func getEnv(key, fallback string) string {
 		return value
 	}
 
-	return fallback
-}
-
-func getEnvAsInt(key string, fallback int64) int64 {
-	if value, ok := os.LookupEnv(key); ok {
-		i, err := strconv.ParseInt(value, 10, 64)
-		if err != nil {
-			return fallback
-		}
-
-		return i
-	}
-
 	return fallback
 }
\ No newline at end of file
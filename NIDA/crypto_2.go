@@ -1,79 +1,50 @@
 package main
 
 import (
-	"crypto"
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/sha1"
-	"fmt"
+	"errors"
 )
 
-func encryptAESKeyAndIVBytes(cfg *Config, aesKey, aesIV []byte) ([]byte, []byte, error) {
-	encryptedAESKey, err := rsa.EncryptPKCS1v15(rand.Reader, cfg.MessageSecurityPubKey, aesKey)
+func encryptAESKeyAndIVBytes(keys KeyProvider, suite CryptoSuite, aesKey, aesIV []byte) ([]byte, []byte, error) {
+	publicKey, err := encryptionKey(keys)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	encryptedAESIV, err := rsa.EncryptPKCS1v15(rand.Reader, cfg.MessageSecurityPubKey, aesIV)
+	encryptedAESKey, err := suite.WrapKey(publicKey, aesKey)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	return encryptedAESKey, encryptedAESIV, nil
-}
-
-func encryptPayloadBytes(payload []byte, aesKey, aesIV []byte) ([]byte, error) {
-	block, err := aes.NewCipher(aesKey)
+	encryptedAESIV, err := suite.WrapKey(publicKey, aesIV)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	paddedPayload := pad(payload, aes.BlockSize)
-	ciphertext := make([]byte, aes.BlockSize+len(paddedPayload))
-	iv := aesIV
-	copy(ciphertext[:aes.BlockSize], iv)
-
-	mode := cipher.NewCBCEncrypter(block, iv)
-	mode.CryptBlocks(ciphertext[aes.BlockSize:], paddedPayload)
-
-	return ciphertext, nil
+	return encryptedAESKey, encryptedAESIV, nil
 }
 
-func signPayloadBytes(payload []byte, privateKey *rsa.PrivateKey) ([]byte, error) {
-	h := sha1.New()
-	h.Write(payload)
-	hashed := h.Sum(nil)
+func encryptPayloadBytes(suite CryptoSuite, payload []byte, aesKey, aesIV []byte) ([]byte, error) {
+	return suite.Encrypt(payload, aesKey, aesIV)
+}
 
-	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA1, hashed)
+func signPayloadBytes(keys KeyProvider, suite CryptoSuite, payload []byte) ([]byte, error) {
+	privateKey, err := signingKey(keys)
 	if err != nil {
 		return nil, err
 	}
 
-	return signature, nil
-}
-
-func decryptPayloadBytes(ciphertext []byte, aesKey, aesIV []byte) ([]byte, error) {
-	block, err := aes.NewCipher(aesKey)
+	signature, err := suite.Sign(privateKey, payload)
 	if err != nil {
 		return nil, err
 	}
 
-	// Use CBC mode
-	if len(ciphertext) < aes.BlockSize {
-		return nil, fmt.Errorf("ciphertext too short")
-	}
-	iv := aesIV
-	ciphertext = ciphertext[aes.BlockSize:]
-
-	// Decrypt the payload
-	mode := cipher.NewCBCDecrypter(block, iv)
-	mode.CryptBlocks(ciphertext, ciphertext)
+	return signature, nil
+}
 
-	// Unpad the decrypted payload (assuming PKCS7 padding)
-	paddingLength := int(ciphertext[len(ciphertext)-1])
-	decryptedPayload := ciphertext[:len(ciphertext)-paddingLength]
+// errDecrypt is returned for every malformed ciphertext so that callers
+// cannot tell a length problem from a padding problem.
+var errDecrypt = errors.New("decryption failed")
 
-	return decryptedPayload, nil
+func decryptPayloadBytes(suite CryptoSuite, ciphertext []byte, aesKey, aesIV []byte) ([]byte, error) {
+	return suite.Decrypt(ciphertext, aesKey, aesIV)
 }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+var (
+	testAESKey = bytes.Repeat([]byte{0x42}, 32)
+	testAESIV  = bytes.Repeat([]byte{0x24}, 16)
+)
+
+func FuzzUnpad(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte("fifteen bytes!!"))
+	f.Add(append(bytes.Repeat([]byte{'a'}, 15), 0))
+	f.Add(append(bytes.Repeat([]byte{'a'}, 15), 17))
+	f.Add(append(bytes.Repeat([]byte{'a'}, 14), 3, 2))
+	f.Add(pad([]byte("payload"), 16))
+	f.Add(pad(nil, 16))
+
+	f.Fuzz(func(t *testing.T, src []byte) {
+		got, err := unpad(src, 16)
+
+		// A straightforward, variable time reference implementation.
+		var want []byte
+		valid := len(src) > 0 && len(src)%16 == 0
+		if valid {
+			padLen := int(src[len(src)-1])
+			valid = padLen >= 1 && padLen <= 16
+			for i := 1; valid && i <= padLen; i++ {
+				valid = src[len(src)-i] == byte(padLen)
+			}
+			if valid {
+				want = src[:len(src)-padLen]
+			}
+		}
+
+		if !valid {
+			if !errors.Is(err, errDecrypt) {
+				t.Fatalf("unpad(%x) = %x, %v; want errDecrypt", src, got, err)
+			}
+			return
+		}
+		if err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("unpad(%x) = %x, %v; want %x", src, got, err, want)
+		}
+	})
+}
+
+func FuzzDecrypt(f *testing.F) {
+	suites := []CryptoSuite{
+		{Cipher: CipherAESCBC, IVMode: IVWrapped},
+		{Cipher: CipherAESCBC, IVMode: IVPrefixed},
+		{Cipher: CipherAESCBC, IVMode: IVInCryptoInfo},
+		{Cipher: CipherAESGCM, IVMode: IVPrefixed},
+		{Cipher: CipherAESGCM, IVMode: IVInCryptoInfo},
+	}
+
+	f.Add([]byte{})
+	f.Add(bytes.Repeat([]byte{1}, 15))
+	f.Add(bytes.Repeat([]byte{1}, 16))
+	f.Add(bytes.Repeat([]byte{1}, 33))
+	f.Add(bytes.Repeat([]byte{1}, 48))
+	for _, suite := range suites {
+		iv := testAESIV[:suite.IVSize()]
+		ciphertext, err := suite.Encrypt([]byte("<Payload><NIN>19900101</NIN></Payload>"), testAESKey, iv)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(ciphertext)
+
+		// Flip a bit of the last block, which breaks the padding or tag.
+		tampered := bytes.Clone(ciphertext)
+		tampered[len(tampered)-1] ^= 1
+		f.Add(tampered)
+	}
+
+	f.Fuzz(func(t *testing.T, ciphertext []byte) {
+		for _, suite := range suites {
+			plaintext, err := suite.Decrypt(ciphertext, testAESKey, testAESIV[:suite.IVSize()])
+			if err != nil && !errors.Is(err, errDecrypt) {
+				t.Fatalf("%+v: Decrypt(%x) = %v; want errDecrypt", suite, ciphertext, err)
+			}
+			if err != nil {
+				continue
+			}
+
+			again, err := suite.Encrypt(plaintext, testAESKey, testAESIV[:suite.IVSize()])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if suite.IVMode == IVInCryptoInfo && !bytes.Equal(again, ciphertext) {
+				t.Fatalf("%+v: %x decrypts to %x, which encrypts to %x", suite, ciphertext, plaintext, again)
+			}
+		}
+	})
+}
+
+func TestDecryptRejectsMalformedInput(t *testing.T) {
+	suite := CryptoSuite{Cipher: CipherAESCBC, IVMode: IVInCryptoInfo}
+	valid, err := suite.Encrypt([]byte("payload"), testAESKey, testAESIV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	badPadding := bytes.Clone(valid)
+	badPadding[len(badPadding)-1] ^= 0xff
+
+	tests := []struct {
+		name       string
+		ciphertext []byte
+	}{
+		{"empty", nil},
+		{"short", valid[:15]},
+		{"not block aligned", append(bytes.Clone(valid), 1)},
+		{"bad padding", badPadding},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := suite.Decrypt(tt.ciphertext, testAESKey, testAESIV); !errors.Is(err, errDecrypt) {
+				t.Fatalf("Decrypt = %v, want errDecrypt", err)
+			}
+		})
+	}
+}
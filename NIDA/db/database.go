@@ -2,7 +2,6 @@ package dbase
 
 import (
 	"database/sql"
-	"log"
 
 	"github.com/go-sql-driver/mysql"
 )
@@ -10,9 +9,8 @@ import (
 func NewMySQLStorage(cfg mysql.Config) (*sql.DB, error) {
 	db, err := sql.Open("mysql", cfg.FormatDSN())
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	return db, nil
 }
-
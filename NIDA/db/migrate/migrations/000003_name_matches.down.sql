@@ -0,0 +1,3 @@
+ALTER TABLE merchants DROP COLUMN kycTier;
+ALTER TABLE merchants DROP COLUMN dateOfBirth;
+DROP TABLE IF EXISTS name_matches;
\ No newline at end of file
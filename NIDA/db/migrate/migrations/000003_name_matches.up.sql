@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS name_matches (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    merchant_id INT UNSIGNED NOT NULL,
+    tier VARCHAR(32) NOT NULL,
+    name_score DECIMAL(5,4) NOT NULL,
+    dob_score DECIMAL(5,4) NULL,
+    verdict ENUM('match', 'review', 'no_match') NOT NULL,
+    createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+);
+
+ALTER TABLE merchants ADD COLUMN dateOfBirth DATE NULL AFTER email;
+-- kycTier names the name_match tier a merchant is held to. It is set by
+-- operations, never from a merchant's own request.
+ALTER TABLE merchants ADD COLUMN kycTier VARCHAR(32) NOT NULL DEFAULT 'basic' AFTER dateOfBirth;
+
+-- Adding index for columns that may be frequently queried
+CREATE INDEX idx_name_matches_merchant_id ON name_matches (merchant_id);
+CREATE INDEX idx_name_matches_verdict ON name_matches (verdict);
+
+-- Adding index for columns that are frequently updated
+CREATE INDEX idx_name_matches_createdAt ON name_matches (createdAt);
\ No newline at end of file
@@ -1,8 +1,8 @@
 package main
 
 import (
-	dbase "NIDA/db"
-	"bytes"
+	"context"
+	"database/sql"
 	"encoding/xml"
 	"net/http"
 	"time"
@@ -34,9 +34,12 @@ type Merchant struct {
 	Telephone string `json:"telephone"`
 	NIN       string `json:"NIN"`
 	Email     string `json:"email"`
+	// DateOfBirth is optional, YYYY-MM-DD. Enhanced KYC matches it against
+	// NIDA's record.
+	DateOfBirth string `json:"dateOfBirth"`
 }
 
-func verifyHandler(c *gin.Context) {
+func (h *Handlers) verifyHandler(c *gin.Context) {
 	var request IRequest
 	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -44,16 +47,25 @@ func verifyHandler(c *gin.Context) {
 	}
 
 	// Decrypt the AES key and IV using RSA
-	aesKey, aesIV, err := decryptCryptoInfo(request.Body.CryptoInfo)
+	ctx := c.Request.Context()
+	var aesKey, aesIV []byte
+	err := traceStep(ctx, "crypto.key_unwrap", func() (err error) {
+		aesKey, aesIV, err = decryptCryptoInfo(h.Config.Keys, legacyCryptoSuite, request.Body.CryptoInfo)
+		return err
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decrypt crypto info"})
+		rejectEnvelope(c)
 		return
 	}
 
 	// Decrypt the payload using the AES key and IV
-	decryptedPayload, err := decryptPayload(request.Body.Payload, aesKey, aesIV)
+	var decryptedPayload string
+	err = traceStep(ctx, "crypto.decrypt", func() (err error) {
+		decryptedPayload, err = decryptPayload(legacyCryptoSuite, request.Body.Payload, aesKey, aesIV)
+		return err
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decrypt payload"})
+		rejectEnvelope(c)
 		return
 	}
 
@@ -63,46 +75,54 @@ func verifyHandler(c *gin.Context) {
 		NIN     string   `xml:"NIN"`
 	}
 	if err := xml.Unmarshal([]byte(decryptedPayload), &payload); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payload"})
+		rejectEnvelope(c)
 		return
 	}
 
-	// Create a HTTP request
-	sampleRequest, err := http.NewRequest("POST", "https://nacer01/TZ_CIG/GatewayService.svc", bytes.NewBuffer([]byte{}))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create dummy request"})
+	if !h.Limiter.AllowNIN(c, payload.NIN) {
 		return
 	}
 
 	// Request the first question from NIDA
-	questionResponse, err := requestQuestionFromNIDA(sampleRequest, payload.NIN)
+	question, err := h.Client.RequestQuestion(ctx, payload.NIN)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		gatewayError(c, err)
 		return
 	}
 
+	verificationFunnelTotal.WithLabelValues(funnelQuestionIssued).Inc()
+
 	// Send the question back to the client
-	c.JSON(http.StatusOK, gin.H{"question": questionResponse})
+	c.JSON(http.StatusOK, gin.H{"question": question.RQQuestion})
 	
 }
 
-func emailHandler(c *gin.Context) {
-    // Retrieve the nin from the query parameters
-    nin := c.Query("nin")
+// rejectEnvelope answers a /verify envelope that cannot be unwrapped,
+// decrypted or parsed. The answer is the same whichever step failed, so
+// that callers cannot use it as a padding oracle.
+func rejectEnvelope(c *gin.Context) {
+	c.JSON(http.StatusBadRequest, gin.H{"error": "invalid envelope"})
+}
+
+func (h *Handlers) emailHandler(c *gin.Context) {
+	// Retrieve the nin from the query parameters
+	nin := c.Query("nin")
 
-    if nin == "" {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "nin parameter is required"})
-        return
-    }
+	if nin == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "nin parameter is required"})
+		return
+	}
 
-    // Call the emailTrigger function
-    emailTrigger(nin)
+	// Send the email in the background; shutdown waits for it
+	h.Background.Go(c.Request.Context(), func(ctx context.Context) {
+		emailTrigger(ctx, h.DB, h.Config.Server.PublicHost, nin)
+	})
 
-    // Respond with a success message
-    c.JSON(http.StatusOK, gin.H{"message": "Email trigger initiated successfully"})
+	// Respond with a success message
+	c.JSON(http.StatusOK, gin.H{"message": "Email trigger initiated successfully"})
 }
 
-func verifyAnswerHandler(c *gin.Context) {
+func (h *Handlers) verifyAnswerHandler(c *gin.Context) {
 	// Parse the request JSON body into a struct
 	type request struct {
 		NIN    string `json:"nin"`
@@ -115,23 +135,36 @@ func verifyAnswerHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
 		return
 	}
-
-	// Call the verifyAnswerWithNIDA function
-	result, err := verifyAnswerWithNIDA(req.NIN, req.RQCode, req.Answer)
+	verificationFunnelTotal.WithLabelValues(funnelAnswerSubmitted).Inc()
+
+	// Answer the question with NIDA
+	result, err := h.Client.RQVerificationAnswer(c.Request.Context(), &RQVerificationAnswerRequest{
+		NIN:    req.NIN,
+		RQCode: req.RQCode,
+		QNANSW: req.Answer,
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		gatewayError(c, err)
 		return
 	}
+	verificationFunnelTotal.WithLabelValues(funnelAnswerChecked).Inc()
+
+	// The last answer comes back without a further question. Like a failed
+	// fingerprint match, a failed flow is not cached so the merchant can
+	// start again.
+	if result.RQCode == "" && result.Code == gatewayCodeOK {
+		ctx := c.Request.Context()
+		outcome := VerificationOutcome{Verified: true, VerifiedAt: time.Now()}
+		if err := h.Cache.Put(ctx, h.Config.hashNIN(req.NIN), opRQVerificationAnswer, outcome); err != nil {
+			loggerFrom(ctx).Error("verification cache", "err", err)
+		}
+	}
 
 	// Return the result as JSON
-	c.JSON(http.StatusOK, result)
+	c.JSON(http.StatusOK, result.RQQuestion)
 }
 
-
-func registerMerchant(c *gin.Context) {
-	//retrieve cfg database configuration
-	cfg := initCFG()
-
+func (h *Handlers) registerMerchant(c *gin.Context) {
 	var merchant Merchant
 	if err := c.ShouldBindJSON(&merchant); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -139,21 +172,17 @@ func registerMerchant(c *gin.Context) {
 	}
 
 	// Add merchant to the database
-
-	db, err := dbase.NewMySQLStorage(cfg)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	
-	defer db.Close()
-
-	_, err = db.Exec("INSERT INTO merchants (firstName, lastName, telephone, NIN, email) VALUES (?, ?, ?, ?, ?)", merchant.FirstName, merchant.LastName, merchant.Telephone, merchant.NIN, merchant.Email)
+	dateOfBirth := sql.NullString{String: merchant.DateOfBirth, Valid: merchant.DateOfBirth != ""}
+	query := "INSERT INTO merchants (firstName, lastName, telephone, NIN, email, dateOfBirth) VALUES (?, ?, ?, ?, ?, ?)"
+	ctx, span := startDBSpan(c.Request.Context(), "INSERT", "merchants", query)
+	_, err := h.DB.ExecContext(ctx, query, merchant.FirstName, merchant.LastName, merchant.Telephone, merchant.NIN, merchant.Email, dateOfBirth)
+	endSpan(span, err)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	verificationFunnelTotal.WithLabelValues(funnelRegistered).Inc()
 
 	c.JSON(http.StatusOK, gin.H{"message": "Merchant registered successfully"})
 }
\ No newline at end of file
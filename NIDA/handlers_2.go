@@ -1,15 +1,19 @@
 package main
 
 import (
-	"bytes"
-	"crypto"
+	"context"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/rsa"
-	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
 	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/xml"
+	"errors"
 	"fmt"
-	"io"
+	"math"
 	"net/http"
 	"strconv"
 	"time"
@@ -18,7 +22,15 @@ import (
 )
 
 type Handlers struct {
-	Config *Config
+	Config  *Config
+	Client  *NidaClient
+	Limiter *APILimiter
+	Cache   VerificationCache
+	// DB is the connection pool shared by all handlers.
+	DB *sql.DB
+	// Background runs work that continues after the response, which
+	// shutdown waits for.
+	Background *Background
 }
 
 type verifyRequest struct {
@@ -31,29 +43,296 @@ func (h *Handlers) verify(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	// Query db using merchant id
-	nin, err := queryNin(request.MerchantID)
+	nin, ok := h.merchantNIN(c, request.MerchantID)
+	if !ok {
+		return
+	}
+	if !h.Limiter.AllowNIN(c, nin) {
+		return
+	}
+
+	// A NIN that already answered its questions is not asked again.
+	ctx := c.Request.Context()
+	if !h.bypassCache(c) {
+		outcome, ok, err := h.Cache.Get(ctx, h.Config.hashNIN(nin), opRQVerificationAnswer)
+		if err != nil {
+			loggerFrom(ctx).Error("verification cache", "err", err)
+		} else if ok {
+			c.JSON(http.StatusOK, gin.H{"outcome": outcome, "cached": true})
+			return
+		}
+	}
+
+	// Request the first question from NIDA
+	question, err := h.Client.RequestQuestion(ctx, nin)
+	if err != nil {
+		gatewayError(c, err)
+		return
+	}
+
+	verificationFunnelTotal.WithLabelValues(funnelQuestionIssued).Inc()
+
+	// Send the question back to the client
+	c.JSON(http.StatusOK, gin.H{"question": question.RQQuestion})
+}
+
+func (h *Handlers) demographics(c *gin.Context) {
+	var request verifyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	nin, ok := h.merchantNIN(c, request.MerchantID)
+	if !ok {
+		return
+	}
+	if !h.Limiter.AllowNIN(c, nin) {
+		return
+	}
+
+	demographics, err := h.Client.LookupDemographics(c.Request.Context(), nin)
+	if err != nil {
+		gatewayError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"demographics": demographics})
+}
+
+func (h *Handlers) photo(c *gin.Context) {
+	var request verifyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	nin, ok := h.merchantNIN(c, request.MerchantID)
+	if !ok {
+		return
+	}
+	if !h.Limiter.AllowNIN(c, nin) {
+		return
+	}
+
+	photo, err := h.Client.RetrievePhoto(c.Request.Context(), nin)
+	if err != nil {
+		gatewayError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"photo": photo})
+}
+
+type fingerprintRequest struct {
+	MerchantID uint64 `json:"merchant_id"`
+	// Finger is the CIG finger code, e.g. R1 for the right thumb.
+	Finger string `json:"finger" binding:"required"`
+	// Format is the template format, e.g. ISO19794-2.
+	Format string `json:"format" binding:"required"`
+	// Template is the base64 encoded, pre-captured template.
+	Template []byte `json:"template" binding:"required"`
+}
+
+func (h *Handlers) fingerprint(c *gin.Context) {
+	var request fingerprintRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	nin, ok := h.merchantNIN(c, request.MerchantID)
+	if !ok {
+		return
+	}
+	if !h.Limiter.AllowNIN(c, nin) {
+		return
+	}
+
+	ctx, ninHash, cacheOp := c.Request.Context(), h.Config.hashNIN(nin), h.fingerprintCacheOperation(request)
+	if !h.bypassCache(c) {
+		outcome, ok, err := h.Cache.Get(ctx, ninHash, cacheOp)
+		if err != nil {
+			loggerFrom(ctx).Error("verification cache", "err", err)
+		} else if ok {
+			c.JSON(http.StatusOK, gin.H{"outcome": outcome, "cached": true})
+			return
+		}
+	}
+
+	match, err := h.Client.VerifyFingerprint(ctx, nin, request.Finger, request.Format, request.Template)
+	if err != nil {
+		gatewayError(c, err)
+		return
+	}
+
+	if match.Matched {
+		verificationFunnelTotal.WithLabelValues(funnelFingerprintMatched).Inc()
+	} else {
+		verificationFunnelTotal.WithLabelValues(funnelFingerprintNotMatched).Inc()
+	}
+
+	// A failed match is not cached, so a merchant can retry after a bad
+	// capture.
+	outcome := VerificationOutcome{Verified: match.Matched, VerifiedAt: time.Now(), TransactionID: match.TransactionID}
+	if outcome.Verified {
+		if err := h.Cache.Put(ctx, ninHash, cacheOp, outcome); err != nil {
+			loggerFrom(ctx).Error("verification cache", "err", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"outcome": outcome, "cached": false})
+}
+
+// fingerprintCacheOperation is the cache operation for the gateway result of
+// one capture: the same finger, format and template. Any other capture goes
+// to the gateway. The capture is keyed with nin_hash_key like the NIN.
+func (h *Handlers) fingerprintCacheOperation(request fingerprintRequest) string {
+	mac := hmac.New(sha256.New, []byte(h.Config.NINHashKey))
+	for _, part := range [][]byte{[]byte(request.Finger), []byte(request.Format), request.Template} {
+		// Length prefixes keep the parts from running into each other.
+		binary.Write(mac, binary.BigEndian, uint64(len(part)))
+		mac.Write(part)
+	}
+	return opFingerprintVerification + ":" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// invalidateVerification drops the cached outcomes of a merchant's NIN, so
+// the next verification goes to the gateway.
+func (h *Handlers) invalidateVerification(c *gin.Context) {
+	merchantID, err := strconv.ParseUint(c.Param("merchant_id"), 10, 64)
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid merchant id"})
+		return
+	}
+	nin, ok := h.merchantNIN(c, merchantID)
+	if !ok {
+		return
+	}
+
+	if err := h.Cache.Invalidate(c.Request.Context(), h.Config.hashNIN(nin)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Request the first question from NIDA
-	err = requestQuestionFromNIDA1(h.Config, nin)
+	c.Status(http.StatusNoContent)
+}
+
+type nameMatchRequest struct {
+	MerchantID uint64 `json:"merchant_id" binding:"required"`
+}
+
+// nameMatch compares a merchant's registered names and date of birth with
+// NIDA's demographics and records the verdict against the merchant. The KYC
+// tier comes from the merchant record so that a caller cannot pick a laxer
+// one.
+func (h *Handlers) nameMatch(c *gin.Context) {
+	var request nameMatchRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	query := "SELECT firstName, lastName, NIN, dateOfBirth, kycTier FROM merchants WHERE id = ?"
+	ctx, span := startDBSpan(c.Request.Context(), "SELECT", "merchants", query)
+	var merchant Merchant
+	var dateOfBirth sql.NullString
+	var tier string
+	err := h.DB.QueryRowContext(ctx, query, request.MerchantID).
+		Scan(&merchant.FirstName, &merchant.LastName, &merchant.NIN, &dateOfBirth, &tier)
+	endSpan(span, err)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "merchant not found"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	merchant.DateOfBirth = dateOfBirth.String
+	if _, ok := h.Config.NameMatch.Tiers[tier]; !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("merchant kyc tier %q is not configured", tier)})
+		return
+	}
+	if !h.Limiter.AllowNIN(c, merchant.NIN) {
+		return
+	}
 
-	// Send the question back to the client
-	c.JSON(http.StatusOK, gin.H{"question": nil})
+	demographics, err := h.Client.LookupDemographics(c.Request.Context(), merchant.NIN)
+	if err != nil {
+		gatewayError(c, err)
+		return
+	}
+
+	result, err := h.Config.NameMatch.matchMerchant(tier, merchant, demographics)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	query = "INSERT INTO name_matches (merchant_id, tier, name_score, dob_score, verdict) VALUES (?, ?, ?, ?, ?)"
+	ctx, span = startDBSpan(c.Request.Context(), "INSERT", "name_matches", query)
+	_, err = h.DB.ExecContext(ctx, query, request.MerchantID, result.Tier, result.NameScore, result.DOBScore, result.Verdict)
+	endSpan(span, err)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	verificationFunnelTotal.WithLabelValues(nameMatchStage(result.Verdict)).Inc()
+
+	c.JSON(http.StatusOK, gin.H{"name_match": result})
 }
 
-func queryNin(_ uint64) (string, error) {
-	return "NIN", nil
+// gatewayError responds to a failed gateway call. SOAP faults are the
+// gateway's answer and map to 502 with the fault code.
+func gatewayError(c *gin.Context, err error) {
+	var fault *SOAPFault
+	if errors.As(err, &fault) {
+		c.JSON(http.StatusBadGateway, gin.H{"error": fault.String, "fault_code": fault.Code})
+		return
+	}
+
+	var codeErr *GatewayCodeError
+	if errors.As(err, &codeErr) {
+		c.JSON(http.StatusBadGateway, gin.H{"error": codeErr.Error(), "gateway_code": codeErr.Code})
+		return
+	}
+
+	var open *CircuitOpenError
+	if errors.As(err, &open) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(open.RetryAfter.Seconds()))))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "NIDA gateway unavailable"})
+		return
+	}
+
+	if errors.Is(err, errRateLimited) {
+		c.Header("Retry-After", "1")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "NIDA gateway quota exhausted"})
+		return
+	}
+
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// merchantNIN looks up the NIN a merchant registered with. When there is
+// none it responds to c and returns false.
+func (h *Handlers) merchantNIN(c *gin.Context, merchantID uint64) (string, bool) {
+	query := "SELECT NIN FROM merchants WHERE id = ?"
+	ctx, span := startDBSpan(c.Request.Context(), "SELECT", "merchants", query)
+	var nin string
+	err := h.DB.QueryRowContext(ctx, query, merchantID).Scan(&nin)
+	endSpan(span, err)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "merchant not found"})
+		return "", false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return "", false
+	}
+
+	return nin, true
 }
 
 type SoapHeader struct {
+	XMLNS          string    `xml:"xmlns,attr,omitempty"`
 	Id             string    `xml:"Id"`
 	Timestamp      time.Time `xml:"TimeStamp"`
 	ClientNameOrIP string    `xml:"ClientNameorIP"`
@@ -89,15 +368,18 @@ type SoapCryptoInfo struct {
 }
 
 type SoapBody struct {
+	XMLNS      string         `xml:"xmlns,attr,omitempty"`
 	CryptoInfo SoapCryptoInfo `xml:"CryptoInfo"`
 	Payload    EncodedBytes   `xml:"Payload"`
 	Signature  EncodedBytes   `xml:"Signature"`
+	Fault      *rawSOAPFault  `xml:"Fault,omitempty"`
 }
 
 type SoapRequest struct {
 	Header  SoapHeader `xml:"soap:Header"`
 	Body    SoapBody   `xml:"soap:Body"`
 	XMLName xml.Name   `xml:"soap:Envelope"`
+	SoapNS  string     `xml:"xmlns:soap,attr"`
 }
 
 type SoapResponse struct {
@@ -106,33 +388,53 @@ type SoapResponse struct {
 	XMLName xml.Name   `xml:"Envelope"`
 }
 
-func (sr SoapResponse) Payload(cfg *Config) ([]byte, error) {
-	hasher := sha1.New()
-	hasher.Write(sr.Body.Payload)
-	if err := rsa.VerifyPKCS1v15(cfg.MessageSecurityPubKey, crypto.SHA1, hasher.Sum(nil), sr.Body.Signature); err != nil {
-		return nil, err
-	}
-
-	aesKey, err := rsa.DecryptPKCS1v15(nil, cfg.StakeholderPrivKey, sr.Body.CryptoInfo.EncryptedCryptoKey)
+// Payload verifies the signature of the response and decrypts its payload,
+// tracing each step under ctx.
+func (sr SoapResponse) Payload(ctx context.Context, cfg *Config) ([]byte, error) {
+	err := traceStep(ctx, "crypto.verify", func() error {
+		return verifyWithMessageSecurityKeys(cfg.Keys, func(pubKey *rsa.PublicKey) error {
+			return cfg.Suite.Verify(pubKey, sr.Body.Payload, sr.Body.Signature)
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	aesIV, err := rsa.DecryptPKCS1v15(nil, cfg.StakeholderPrivKey, sr.Body.CryptoInfo.EncryptedCryptoIV)
+	var aesKey, aesIV []byte
+	err = traceStep(ctx, "crypto.key_unwrap", func() error {
+		return decryptWithStakeholderKeys(cfg.Keys, func(privKey *rsa.PrivateKey) error {
+			aesKey, err = cfg.Suite.UnwrapKey(privKey, sr.Body.CryptoInfo.EncryptedCryptoKey)
+			if err != nil {
+				return err
+			}
+			// In prefixed mode the IV is read from the payload instead,
+			// every other mode uses the wrapped IV
+			if cfg.Suite.IVMode == IVPrefixed {
+				return nil
+			}
+			aesIV, err = cfg.Suite.UnwrapKey(privKey, sr.Body.CryptoInfo.EncryptedCryptoIV)
+			return err
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return decryptPayloadBytes(sr.Body.Payload, aesKey, aesIV)
+	var payload []byte
+	err = traceStep(ctx, "crypto.decrypt", func() (err error) {
+		payload, err = decryptPayloadBytes(cfg.Suite, sr.Body.Payload, aesKey, aesIV)
+		return err
+	})
+	return payload, err
 }
 
-func generateAESKeyAndIV() ([]byte, []byte, error) {
+func generateAESKeyAndIV(suite CryptoSuite) ([]byte, []byte, error) {
 	key := make([]byte, 32)
 	if _, err := rand.Read(key); err != nil {
 		return nil, nil, err
 	}
 
-	iv := make([]byte, 16)
+	iv := make([]byte, suite.IVSize())
 	if _, err := rand.Read(iv); err != nil {
 		return nil, nil, err
 	}
@@ -140,45 +442,58 @@ func generateAESKeyAndIV() ([]byte, []byte, error) {
 	return key, iv, nil
 }
 
-type QuestionPayload struct {
-	NIN string `xml:"NIN"`
-}
-
-func requestQuestionFromNIDA1(cfg *Config, nin string) error {
-	aesKey, aesIV, err := generateAESKeyAndIV()
+// newSoapRequest encrypts and signs payload into a request envelope,
+// tracing each step under ctx.
+func newSoapRequest(ctx context.Context, cfg *Config, clientNameOrIP string, payload any) (SoapRequest, error) {
+	aesKey, aesIV, err := generateAESKeyAndIV(cfg.Suite)
 	if err != nil {
-		return err
+		return SoapRequest{}, err
 	}
 
-	payload, err := xml.Marshal(QuestionPayload{NIN: nin})
+	payloadBytes, err := xml.Marshal(payload)
 	if err != nil {
-		return err
+		return SoapRequest{}, err
 	}
 
-	encryptedPayload, err := encryptPayloadBytes(payload, aesKey, aesIV)
-	if err != nil {
+	var encryptedPayload []byte
+	err = traceStep(ctx, "crypto.encrypt", func() (err error) {
+		encryptedPayload, err = encryptPayloadBytes(cfg.Suite, payloadBytes, aesKey, aesIV)
 		return err
+	})
+	if err != nil {
+		return SoapRequest{}, err
 	}
 
-	encryptedPayloadSignature, err := signPayloadBytes(encryptedPayload, cfg.StakeholderPrivKey)
-	if err != nil {
+	var encryptedPayloadSignature []byte
+	err = traceStep(ctx, "crypto.sign", func() (err error) {
+		encryptedPayloadSignature, err = signPayloadBytes(cfg.Keys, cfg.Suite, encryptedPayload)
 		return err
+	})
+	if err != nil {
+		return SoapRequest{}, err
 	}
 
-	encryptedAESKey, encryptedAESIV, err := encryptAESKeyAndIVBytes(cfg, aesKey, aesIV)
-	if err != nil {
+	var encryptedAESKey, encryptedAESIV []byte
+	err = traceStep(ctx, "crypto.key_wrap", func() (err error) {
+		encryptedAESKey, encryptedAESIV, err = encryptAESKeyAndIVBytes(cfg.Keys, cfg.Suite, aesKey, aesIV)
 		return err
+	})
+	if err != nil {
+		return SoapRequest{}, err
 	}
 
 	now := time.Now()
-	req := SoapRequest{
+	return SoapRequest{
+		SoapNS: cfg.SOAP.Version.namespace(),
 		Header: SoapHeader{
+			XMLNS:          cfg.SOAP.Namespace,
 			Id:             strconv.FormatInt(now.UnixNano(), 10),
 			Timestamp:      now,
-			ClientNameOrIP: "test",
+			ClientNameOrIP: clientNameOrIP,
 			UserID:         cfg.UserID,
 		},
 		Body: SoapBody{
+			XMLNS: cfg.SOAP.Namespace,
 			CryptoInfo: SoapCryptoInfo{
 				EncryptedCryptoKey: encryptedAESKey,
 				EncryptedCryptoIV:  encryptedAESIV,
@@ -186,32 +501,5 @@ func requestQuestionFromNIDA1(cfg *Config, nin string) error {
 			Payload:   encryptedPayload,
 			Signature: encryptedPayloadSignature,
 		},
-	}
-
-	requestPayload, err := xml.MarshalIndent(req, "", "  ")
-	if err != nil {
-		return err
-	}
-	fmt.Println("REQUEST", string(requestPayload))
-
-	// NIDA API endpoint
-	resp, err := http.Post(cfg.NidaURL, "text/xml", bytes.NewBuffer(requestPayload))
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	// Parse the response
-	var responseEnvelope SoapResponse
-	buf := &bytes.Buffer{}
-	if err := xml.NewDecoder(io.TeeReader(resp.Body, buf)).Decode(&responseEnvelope); err != nil {
-		fmt.Println("RESPONSE", buf.String())
-		return err
-	}
-
-	fmt.Println("RESPONSE", buf.String())
-	rp, err := responseEnvelope.Payload(cfg)
-	fmt.Println("RESPONSE Payload", rp, err)
-
-	return nil
+	}, nil
 }
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HealthConfig configures the readiness checks.
+type HealthConfig struct {
+	// Timeout bounds each check, 2s by default.
+	Timeout Duration `json:"timeout"`
+	// NIDAProbe turns on a reachability probe of the gateway.
+	NIDAProbe NIDAProbeConfig `json:"nida_probe"`
+}
+
+// NIDAProbeConfig configures the gateway probe. The probe sends no
+// envelope: any HTTP response from the gateway URL counts as reachable.
+type NIDAProbeConfig struct {
+	Enabled bool `json:"enabled"`
+	// Interval is how long a probe result is reused, 1m by default, so
+	// that readiness checks do not add to the gateway's load.
+	Interval Duration `json:"interval"`
+	// Required makes the service unready while the gateway is unreachable.
+	// Otherwise the probe is only reported.
+	Required bool `json:"required"`
+}
+
+func (hc HealthConfig) withDefaults() HealthConfig {
+	if hc.Timeout == 0 {
+		hc.Timeout = Duration(2 * time.Second)
+	}
+	if hc.NIDAProbe.Interval == 0 {
+		hc.NIDAProbe.Interval = Duration(time.Minute)
+	}
+	return hc
+}
+
+func (hc HealthConfig) Validate() error {
+	if hc.Timeout < 0 || hc.NIDAProbe.Interval < 0 {
+		return fmt.Errorf("health: timeout and nida_probe.interval must not be negative")
+	}
+	return nil
+}
+
+const (
+	checkOK   = "ok"
+	checkFail = "fail"
+)
+
+// CheckResult is the state of one dependency in the /readyz response.
+type CheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Required is false for checks that are reported but do not affect
+	// readiness.
+	Required bool `json:"required"`
+	// ExpiresAt is when the key material stops being valid.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// CheckedAt is set on cached results.
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+}
+
+func newCheckResult(err error, required bool) CheckResult {
+	if err != nil {
+		return CheckResult{Status: checkFail, Error: err.Error(), Required: required}
+	}
+	return CheckResult{Status: checkOK, Required: required}
+}
+
+// Health serves /healthz and /readyz.
+type Health struct {
+	cfg HealthConfig
+	// profile is reported so that callers can see which gateway we use.
+	profile string
+	db      *sql.DB
+	keys    KeyProvider
+	client  *NidaClient
+
+	mu    sync.Mutex
+	probe CheckResult
+}
+
+func NewHealth(cfg *Config, db *sql.DB, client *NidaClient) *Health {
+	return &Health{cfg: cfg.Health, profile: cfg.Profile, db: db, keys: cfg.Keys, client: client}
+}
+
+// live reports that the process is serving requests. It checks no
+// dependencies, so that an outage elsewhere does not get us restarted.
+func (h *Health) live(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": checkOK})
+}
+
+// ready reports whether every required dependency is usable, with the
+// result of each check and the active profile.
+func (h *Health) ready(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	checks := map[string]CheckResult{
+		"database": h.checkDatabase(ctx),
+		"keys":     h.checkKeys(),
+	}
+	if h.cfg.NIDAProbe.Enabled {
+		checks["nida"] = h.checkNIDA(ctx)
+	}
+
+	status, code := "ready", http.StatusOK
+	for _, check := range checks {
+		if check.Required && check.Status != checkOK {
+			status, code = "not_ready", http.StatusServiceUnavailable
+		}
+	}
+
+	c.JSON(code, gin.H{"status": status, "profile": h.profile, "checks": checks})
+}
+
+func (h *Health) checkDatabase(ctx context.Context) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(h.cfg.Timeout))
+	defer cancel()
+
+	return newCheckResult(h.db.PingContext(ctx), true)
+}
+
+// checkKeys checks that there is a stakeholder key to sign and decrypt
+// with and a NIDA key to encrypt and verify with, valid now.
+func (h *Health) checkKeys() CheckResult {
+	if _, err := activeStakeholderKeys(h.keys); err != nil {
+		return newCheckResult(err, true)
+	}
+	if _, err := activeMessageSecurityKeys(h.keys); err != nil {
+		return newCheckResult(err, true)
+	}
+
+	result := newCheckResult(nil, true)
+	if expiresAt := h.keysExpireAt(time.Now()); !expiresAt.IsZero() {
+		result.ExpiresAt = &expiresAt
+	}
+	return result
+}
+
+// keysExpireAt is when the keys of either kind valid at now have all
+// expired, or zero if that never happens.
+func (h *Health) keysExpireAt(now time.Time) time.Time {
+	var stakeholder, nida []validity
+
+	privKeys, _ := h.keys.StakeholderKeys()
+	for _, k := range privKeys {
+		stakeholder = append(stakeholder, validity{k.NotBefore, k.NotAfter})
+	}
+
+	pubKeys, _ := h.keys.MessageSecurityKeys()
+	for _, k := range pubKeys {
+		nida = append(nida, validity{k.NotBefore, k.NotAfter})
+	}
+
+	stakeholderExpiry, stakeholderExpires := lastExpiry(stakeholder, now)
+	nidaExpiry, nidaExpires := lastExpiry(nida, now)
+	switch {
+	case !stakeholderExpires:
+		return nidaExpiry
+	case !nidaExpires || stakeholderExpiry.Before(nidaExpiry):
+		return stakeholderExpiry
+	default:
+		return nidaExpiry
+	}
+}
+
+type validity struct {
+	notBefore, notAfter time.Time
+}
+
+// lastExpiry returns when the last of the windows open at now closes, and
+// false when one of them never does.
+func lastExpiry(windows []validity, now time.Time) (time.Time, bool) {
+	var latest time.Time
+	for _, w := range windows {
+		if !validAt(w.notBefore, w.notAfter, now) {
+			continue
+		}
+		if w.notAfter.IsZero() {
+			return time.Time{}, false
+		}
+		if w.notAfter.After(latest) {
+			latest = w.notAfter
+		}
+	}
+	return latest, true
+}
+
+// checkNIDA returns the last probe of the gateway, probing again once the
+// result is older than the interval. The lock is not held during the probe,
+// so a slow gateway does not queue up readiness checks behind it. A probe
+// cut short by the caller says nothing about the gateway and is not kept.
+func (h *Health) checkNIDA(ctx context.Context) CheckResult {
+	h.mu.Lock()
+	last := h.probe
+	h.mu.Unlock()
+
+	if last.CheckedAt != nil && time.Since(*last.CheckedAt) < time.Duration(h.cfg.NIDAProbe.Interval) {
+		return last
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, time.Duration(h.cfg.Timeout))
+	defer cancel()
+
+	result := newCheckResult(h.probeNIDA(probeCtx), h.cfg.NIDAProbe.Required)
+	checkedAt := time.Now()
+	result.CheckedAt = &checkedAt
+
+	if ctx.Err() == nil {
+		h.mu.Lock()
+		h.probe = result
+		h.mu.Unlock()
+	}
+
+	return result
+}
+
+func (h *Health) probeNIDA(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.client.cfg.NidaURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := h.client.http.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
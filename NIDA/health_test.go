@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCheckNIDAKeepsOnlyCompletedProbes(t *testing.T) {
+	var probes atomic.Int32
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+	}))
+	defer gateway.Close()
+
+	h := &Health{
+		cfg: HealthConfig{
+			Timeout:   Duration(time.Second),
+			NIDAProbe: NIDAProbeConfig{Enabled: true, Interval: Duration(time.Minute), Required: true},
+		},
+		client: &NidaClient{cfg: &Config{NidaURL: gateway.URL}, http: gateway.Client()},
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if got := h.checkNIDA(cancelled); got.Status == checkOK {
+		t.Fatalf("probe with a cancelled context: status %q, want a failure", got.Status)
+	}
+
+	for i := range 2 {
+		if got := h.checkNIDA(context.Background()); got.Status != checkOK {
+			t.Fatalf("probe %d: status %q (%s), want %q", i+1, got.Status, got.Error, checkOK)
+		}
+	}
+	if n := probes.Load(); n != 1 {
+		t.Fatalf("gateway probed %d times, want 1: the cancelled probe was kept or the completed one was not", n)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Duration is a time.Duration read from config as a string such as "30s".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(bs []byte) error {
+	var s string
+	if err := json.Unmarshal(bs, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\": %w", err)
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// proxyNone disables proxying, even when HTTPS_PROXY is set.
+const proxyNone = "none"
+
+// HTTPClientConfig configures the HTTP client used to reach the gateway.
+type HTTPClientConfig struct {
+	// ConnectTimeout bounds dialing and the TLS handshake.
+	ConnectTimeout Duration `json:"connect_timeout"`
+	// ReadTimeout bounds the wait for the gateway's response headers once
+	// the request is sent.
+	ReadTimeout Duration `json:"read_timeout"`
+	// Timeout bounds the whole exchange, including reading the body.
+	Timeout Duration `json:"timeout"`
+	// RootCAs is a PEM bundle trusted in addition to the system roots, for
+	// the internal CA behind nacer01.
+	RootCAs string `json:"root_cas"`
+	// ClientCert and ClientKey are a PEM certificate and key presented for
+	// mutual TLS.
+	ClientCert string `json:"client_cert"`
+	ClientKey  string `json:"client_key"`
+	// Proxy is a proxy URL, "none" to connect directly, or empty to use
+	// HTTPS_PROXY and NO_PROXY from the environment.
+	Proxy string `json:"proxy"`
+}
+
+var defaultHTTPClientConfig = HTTPClientConfig{
+	ConnectTimeout: Duration(10 * time.Second),
+	ReadTimeout:    Duration(30 * time.Second),
+	Timeout:        Duration(60 * time.Second),
+}
+
+func (hc HTTPClientConfig) withDefaults() HTTPClientConfig {
+	if hc.ConnectTimeout == 0 {
+		hc.ConnectTimeout = defaultHTTPClientConfig.ConnectTimeout
+	}
+	if hc.ReadTimeout == 0 {
+		hc.ReadTimeout = defaultHTTPClientConfig.ReadTimeout
+	}
+	if hc.Timeout == 0 {
+		hc.Timeout = defaultHTTPClientConfig.Timeout
+	}
+	return hc
+}
+
+func (hc HTTPClientConfig) Validate() error {
+	if hc.ConnectTimeout < 0 || hc.ReadTimeout < 0 || hc.Timeout < 0 {
+		return fmt.Errorf("http timeouts must not be negative")
+	}
+	if (hc.ClientCert == "") != (hc.ClientKey == "") {
+		return fmt.Errorf("http client_cert and client_key must be set together")
+	}
+	if hc.Proxy != "" && hc.Proxy != proxyNone {
+		if _, err := url.Parse(hc.Proxy); err != nil {
+			return fmt.Errorf("http proxy: %w", err)
+		}
+	}
+	return nil
+}
+
+// newHTTPClient builds the client described by hc.
+func newHTTPClient(hc HTTPClientConfig) (*http.Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if hc.RootCAs != "" {
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+
+		bs, err := os.ReadFile(hc.RootCAs)
+		if err != nil {
+			return nil, err
+		}
+		if !roots.AppendCertsFromPEM(bs) {
+			return nil, fmt.Errorf("no certificates found in %s", hc.RootCAs)
+		}
+		tlsConfig.RootCAs = roots
+	}
+
+	if hc.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(hc.ClientCert, hc.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	proxy := http.ProxyFromEnvironment
+	switch hc.Proxy {
+	case "":
+	case proxyNone:
+		proxy = nil
+	default:
+		proxyURL, err := url.Parse(hc.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("http proxy: %w", err)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           (&net.Dialer{Timeout: time.Duration(hc.ConnectTimeout), KeepAlive: 30 * time.Second}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   time.Duration(hc.ConnectTimeout),
+		ResponseHeaderTimeout: time.Duration(hc.ReadTimeout),
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+	}
+
+	return &http.Client{Transport: transport, Timeout: time.Duration(hc.Timeout)}, nil
+}
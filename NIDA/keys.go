@@ -0,0 +1,569 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/youmark/pkcs8"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// KeyProvider supplies the RSA key material used on the NIDA gateway:
+// our stakeholder private keys and NIDA's message security public keys.
+// Several keys of each kind may be configured to allow rotation.
+type KeyProvider interface {
+	StakeholderKeys() ([]StakeholderKey, error)
+	MessageSecurityKeys() ([]MessageSecurityKey, error)
+}
+
+type StakeholderKey struct {
+	ID        string
+	Key       *rsa.PrivateKey
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+type MessageSecurityKey struct {
+	ID        string
+	Key       *rsa.PublicKey
+	NotBefore time.Time
+	NotAfter  time.Time
+
+	// Certificate is nil when the key was configured as a bare public key.
+	Certificate *x509.Certificate
+}
+
+// KeyFile describes one entry of a configured key set. A zero NotBefore or
+// NotAfter leaves that side of the validity window open. Encrypted private
+// keys take their passphrase from PassphraseEnv or PassphraseFile.
+type KeyFile struct {
+	ID             string    `json:"id"`
+	File           string    `json:"file"`
+	NotBefore      time.Time `json:"not_before"`
+	NotAfter       time.Time `json:"not_after"`
+	PassphraseEnv  string    `json:"passphrase_env"`
+	PassphraseFile string    `json:"passphrase_file"`
+}
+
+var errNoActiveKey = errors.New("no active key")
+
+func validAt(notBefore, notAfter, now time.Time) bool {
+	if !notBefore.IsZero() && now.Before(notBefore) {
+		return false
+	}
+	if !notAfter.IsZero() && !now.Before(notAfter) {
+		return false
+	}
+	return true
+}
+
+// activeStakeholderKeys returns the stakeholder keys valid now, newest first.
+func activeStakeholderKeys(keys KeyProvider) ([]*rsa.PrivateKey, error) {
+	all, err := keys.StakeholderKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var active []StakeholderKey
+	for _, k := range all {
+		if validAt(k.NotBefore, k.NotAfter, now) {
+			active = append(active, k)
+		}
+	}
+	if len(active) == 0 {
+		return nil, fmt.Errorf("stakeholder key: %w", errNoActiveKey)
+	}
+
+	sort.SliceStable(active, func(i, j int) bool { return active[i].NotBefore.After(active[j].NotBefore) })
+
+	privKeys := make([]*rsa.PrivateKey, len(active))
+	for i, k := range active {
+		privKeys[i] = k.Key
+	}
+
+	return privKeys, nil
+}
+
+// activeMessageSecurityKeys returns the NIDA keys valid now, newest first.
+func activeMessageSecurityKeys(keys KeyProvider) ([]*rsa.PublicKey, error) {
+	all, err := keys.MessageSecurityKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var active []MessageSecurityKey
+	for _, k := range all {
+		if validAt(k.NotBefore, k.NotAfter, now) {
+			active = append(active, k)
+		}
+	}
+	if len(active) == 0 {
+		return nil, fmt.Errorf("message security key: %w", errNoActiveKey)
+	}
+
+	sort.SliceStable(active, func(i, j int) bool { return active[i].NotBefore.After(active[j].NotBefore) })
+
+	pubKeys := make([]*rsa.PublicKey, len(active))
+	for i, k := range active {
+		pubKeys[i] = k.Key
+	}
+
+	return pubKeys, nil
+}
+
+// signingKey is the stakeholder key used for outgoing signatures.
+func signingKey(keys KeyProvider) (*rsa.PrivateKey, error) {
+	privKeys, err := activeStakeholderKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return privKeys[0], nil
+}
+
+// encryptionKey is the NIDA key used to wrap outgoing AES keys.
+func encryptionKey(keys KeyProvider) (*rsa.PublicKey, error) {
+	pubKeys, err := activeMessageSecurityKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return pubKeys[0], nil
+}
+
+// decryptWithStakeholderKeys runs decrypt with each active stakeholder key
+// until one succeeds.
+func decryptWithStakeholderKeys(keys KeyProvider, decrypt func(*rsa.PrivateKey) error) error {
+	privKeys, err := activeStakeholderKeys(keys)
+	if err != nil {
+		return err
+	}
+
+	for _, privKey := range privKeys {
+		if err = decrypt(privKey); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// verifyWithMessageSecurityKeys accepts a signature made by any currently
+// valid NIDA key.
+func verifyWithMessageSecurityKeys(keys KeyProvider, verify func(*rsa.PublicKey) error) error {
+	pubKeys, err := activeMessageSecurityKeys(keys)
+	if err != nil {
+		return err
+	}
+
+	for _, pubKey := range pubKeys {
+		if err = verify(pubKey); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+type staticKeyProvider struct {
+	privKeys []StakeholderKey
+	pubKeys  []MessageSecurityKey
+}
+
+func (p *staticKeyProvider) StakeholderKeys() ([]StakeholderKey, error) {
+	return p.privKeys, nil
+}
+
+func (p *staticKeyProvider) MessageSecurityKeys() ([]MessageSecurityKey, error) {
+	return p.pubKeys, nil
+}
+
+// NewFileKeyProvider loads stakeholder private keys and NIDA message
+// security certificates from PEM files on disk.
+func NewFileKeyProvider(privKeyFiles, certFiles []KeyFile) (KeyProvider, error) {
+	p := &staticKeyProvider{}
+
+	for _, kf := range privKeyFiles {
+		bs, err := os.ReadFile(kf.File)
+		if err != nil {
+			return nil, err
+		}
+
+		passphrase, err := readPassphrase(kf.PassphraseEnv, kf.PassphraseFile)
+		if err != nil {
+			return nil, err
+		}
+
+		privKey, err := parseStakeholderKey(bs, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", kf.File, err)
+		}
+
+		p.privKeys = append(p.privKeys, StakeholderKey{ID: keyID(kf), Key: privKey, NotBefore: kf.NotBefore, NotAfter: kf.NotAfter})
+	}
+
+	for _, kf := range certFiles {
+		bs, err := os.ReadFile(kf.File)
+		if err != nil {
+			return nil, err
+		}
+
+		pubKey, cert, err := parseMessageSecurityKey(bs)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", kf.File, err)
+		}
+
+		p.pubKeys = append(p.pubKeys, MessageSecurityKey{ID: keyID(kf), Key: pubKey, NotBefore: kf.NotBefore, NotAfter: kf.NotAfter, Certificate: cert})
+	}
+
+	return p, nil
+}
+
+func keyID(kf KeyFile) string {
+	if kf.ID != "" {
+		return kf.ID
+	}
+
+	return filepath.Base(kf.File)
+}
+
+// NewEnvKeyProvider loads PEM encoded key material from environment
+// variables. passphrase decrypts the private key if it is encrypted.
+func NewEnvKeyProvider(privKeyVar, certVar, passphrase string) (KeyProvider, error) {
+	privkeyPEM, ok := os.LookupEnv(privKeyVar)
+	if !ok {
+		return nil, fmt.Errorf("environment variable %s is not set", privKeyVar)
+	}
+
+	pubkeyPEM, ok := os.LookupEnv(certVar)
+	if !ok {
+		return nil, fmt.Errorf("environment variable %s is not set", certVar)
+	}
+
+	privKey, err := parseStakeholderKey([]byte(privkeyPEM), passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	pubKey, cert, err := parseMessageSecurityKey([]byte(pubkeyPEM))
+	if err != nil {
+		return nil, err
+	}
+
+	return &staticKeyProvider{
+		privKeys: []StakeholderKey{{ID: privKeyVar, Key: privKey}},
+		pubKeys:  []MessageSecurityKey{{ID: certVar, Key: pubKey, Certificate: cert}},
+	}, nil
+}
+
+// NewPKCS12KeyProvider loads the stakeholder private key from a PKCS#12
+// keystore and the NIDA certificates from a PKCS#12 truststore.
+func NewPKCS12KeyProvider(keystoreFile, truststoreFile, password string) (KeyProvider, error) {
+	keystorebs, err := os.ReadFile(keystoreFile)
+	if err != nil {
+		return nil, err
+	}
+
+	privKey, err := parseStakeholderKey(keystorebs, password)
+	if err != nil {
+		return nil, fmt.Errorf("decode keystore: %w", err)
+	}
+
+	truststorebs, err := os.ReadFile(truststoreFile)
+	if err != nil {
+		return nil, err
+	}
+
+	certs, err := pkcs12.DecodeTrustStore(truststorebs, password)
+	if err != nil {
+		return nil, fmt.Errorf("decode truststore: %w", err)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("truststore %s has no certificates", truststoreFile)
+	}
+
+	p := &staticKeyProvider{
+		privKeys: []StakeholderKey{{ID: filepath.Base(keystoreFile), Key: privKey}},
+	}
+	for _, cert := range certs {
+		pubKey, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("expected rsa public key but got %T", cert.PublicKey)
+		}
+
+		p.pubKeys = append(p.pubKeys, MessageSecurityKey{ID: cert.Subject.CommonName, Key: pubKey, Certificate: cert})
+	}
+
+	return p, nil
+}
+
+// ReloadingKeyProvider serves keys from the most recent successful load and
+// can be told to reload them without restarting the process.
+type ReloadingKeyProvider struct {
+	load func() (KeyProvider, error)
+
+	mu   sync.RWMutex
+	keys KeyProvider
+}
+
+func NewReloadingKeyProvider(load func() (KeyProvider, error)) (*ReloadingKeyProvider, error) {
+	keys, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReloadingKeyProvider{load: load, keys: keys}, nil
+}
+
+func (p *ReloadingKeyProvider) current() KeyProvider {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.keys
+}
+
+func (p *ReloadingKeyProvider) StakeholderKeys() ([]StakeholderKey, error) {
+	return p.current().StakeholderKeys()
+}
+
+func (p *ReloadingKeyProvider) MessageSecurityKeys() ([]MessageSecurityKey, error) {
+	return p.current().MessageSecurityKeys()
+}
+
+// Reload replaces the served keys. On failure the previous keys stay in use.
+func (p *ReloadingKeyProvider) Reload() error {
+	keys, err := p.load()
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	return nil
+}
+
+// ReloadOnSIGHUP reloads the keys every time the process receives SIGHUP.
+func (p *ReloadingKeyProvider) ReloadOnSIGHUP() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+
+	for range sigs {
+		if err := p.Reload(); err != nil {
+			slog.Error("reload gateway keys", "err", err)
+			continue
+		}
+
+		slog.Info("reloaded gateway keys")
+	}
+}
+
+// keyConfig holds the key material settings of the config file.
+type keyConfig struct {
+	KeyProvider           string    `json:"key_provider"`
+	MessageSecurityPubKey string    `json:"message_security_pub_key"`
+	StakeholderPrivKey    string    `json:"stakeholder_priv_key"`
+	MessageSecurityCerts  []KeyFile `json:"message_security_certs"`
+	StakeholderKeys       []KeyFile `json:"stakeholder_keys"`
+	Keystore              string    `json:"keystore"`
+	Truststore            string    `json:"truststore"`
+	KeystorePasswordEnv   string    `json:"keystore_password_env"`
+	KeystorePasswordFile  string    `json:"keystore_password_file"`
+	// PassphraseEnv and PassphraseFile hold the passphrase of the env
+	// provider's stakeholder key.
+	PassphraseEnv     string `json:"passphrase_env"`
+	PassphraseFile    string `json:"passphrase_file"`
+	CABundle          string `json:"message_security_ca_bundle"`
+	ExpiryWarningDays int    `json:"certificate_expiry_warning_days"`
+}
+
+// keyProvider builds the KeyProvider the settings describe, with the
+// message security certificates validated.
+func (kc keyConfig) keyProvider() (KeyProvider, error) {
+	keys, err := kc.provider()
+	if err != nil {
+		return nil, err
+	}
+
+	var roots *x509.CertPool
+	if kc.CABundle != "" {
+		if roots, err = readCABundle(kc.CABundle); err != nil {
+			return nil, err
+		}
+	}
+
+	warningDays := kc.ExpiryWarningDays
+	if warningDays == 0 {
+		warningDays = defaultCertExpiryWarningDays
+	}
+
+	return validateMessageSecurityKeys(keys, roots, warningDays)
+}
+
+func (kc keyConfig) provider() (KeyProvider, error) {
+	switch kc.KeyProvider {
+	case "", "file":
+		privKeyFiles := kc.StakeholderKeys
+		if kc.StakeholderPrivKey != "" {
+			privKeyFiles = append(privKeyFiles, KeyFile{File: kc.StakeholderPrivKey})
+		}
+
+		certFiles := kc.MessageSecurityCerts
+		if kc.MessageSecurityPubKey != "" {
+			certFiles = append(certFiles, KeyFile{File: kc.MessageSecurityPubKey})
+		}
+
+		return NewFileKeyProvider(privKeyFiles, certFiles)
+	case "env":
+		passphraseEnv := kc.PassphraseEnv
+		if passphraseEnv == "" && kc.PassphraseFile == "" {
+			passphraseEnv = "NIDA_STAKEHOLDER_KEY_PASSPHRASE"
+		}
+		passphrase, err := readPassphrase(passphraseEnv, kc.PassphraseFile)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewEnvKeyProvider("NIDA_STAKEHOLDER_PRIV_KEY", "NIDA_MESSAGE_SECURITY_CERT", passphrase)
+	case "pkcs12":
+		password, err := readPassphrase(kc.KeystorePasswordEnv, kc.KeystorePasswordFile)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewPKCS12KeyProvider(kc.Keystore, kc.Truststore, password)
+	default:
+		return nil, fmt.Errorf("unknown key provider %q", kc.KeyProvider)
+	}
+}
+
+// readPassphrase returns the passphrase held in the environment variable
+// env or, failing that, in file. Both may be empty for unencrypted keys.
+func readPassphrase(env, file string) (string, error) {
+	if env != "" {
+		if passphrase, ok := os.LookupEnv(env); ok {
+			return passphrase, nil
+		}
+	}
+
+	if file != "" {
+		bs, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("read passphrase: %w", err)
+		}
+
+		return strings.TrimRight(string(bs), "\r\n"), nil
+	}
+
+	return "", nil
+}
+
+// parseStakeholderKey decodes an RSA private key given as PKCS#1 or PKCS#8
+// PEM, either optionally passphrase protected, or as a PKCS#12 bundle.
+func parseStakeholderKey(bs []byte, passphrase string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(bs)
+	if block == nil {
+		// Not PEM, so expect a PKCS#12 (.pfx) bundle.
+		key, _, _, err := pkcs12.DecodeChain(bs, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("parse pkcs12 private key: %w", err)
+		}
+
+		return asRSAPrivateKey(key)
+	}
+
+	der := block.Bytes
+	if x509.IsEncryptedPEMBlock(block) {
+		if passphrase == "" {
+			return nil, errors.New("private key is encrypted but no passphrase is configured")
+		}
+
+		var err error
+		der, err = x509.DecryptPEMBlock(block, []byte(passphrase))
+		if err != nil {
+			return nil, fmt.Errorf("decrypt private key: %w", err)
+		}
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privKey, err := x509.ParsePKCS1PrivateKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("parse pkcs1 private key: %w", err)
+		}
+
+		return privKey, nil
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("parse pkcs8 private key: %w", err)
+		}
+
+		return asRSAPrivateKey(key)
+	case "ENCRYPTED PRIVATE KEY":
+		if passphrase == "" {
+			return nil, errors.New("private key is encrypted but no passphrase is configured")
+		}
+
+		key, err := pkcs8.ParsePKCS8PrivateKey(der, []byte(passphrase))
+		if err != nil {
+			return nil, fmt.Errorf("parse encrypted pkcs8 private key: %w", err)
+		}
+
+		return asRSAPrivateKey(key)
+	default:
+		return nil, fmt.Errorf("unsupported private key PEM block %s", block.Type)
+	}
+}
+
+func asRSAPrivateKey(key any) (*rsa.PrivateKey, error) {
+	privKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("expected rsa private key but got %T", key)
+	}
+
+	return privKey, nil
+}
+
+// parseMessageSecurityKey accepts a PEM or DER certificate, or a bare PEM
+// PKIX public key in which case the returned certificate is nil.
+func parseMessageSecurityKey(bs []byte) (*rsa.PublicKey, *x509.Certificate, error) {
+	var key any
+	var cert *x509.Certificate
+	if block, _ := pem.Decode(bs); block != nil && block.Type == "PUBLIC KEY" {
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parse public key: %w", err)
+		}
+		key = pub
+	} else {
+		var err error
+		cert, err = parseCertificate(bs)
+		if err != nil {
+			return nil, nil, err
+		}
+		key = cert.PublicKey
+	}
+
+	pubKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("expected rsa public key but got %T", key)
+	}
+
+	return pubKey, cert, nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youmark/pkcs8"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func testRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestActiveStakeholderKeys(t *testing.T) {
+	now := time.Now()
+	old, current, next := testRSAKey(t), testRSAKey(t), testRSAKey(t)
+
+	tests := []struct {
+		name    string
+		keys    []StakeholderKey
+		want    []*rsa.PrivateKey
+		wantErr error
+	}{
+		{
+			name: "open window",
+			keys: []StakeholderKey{{ID: "current", Key: current}},
+			want: []*rsa.PrivateKey{current},
+		},
+		{
+			name: "rotation overlap, newest first",
+			keys: []StakeholderKey{
+				{ID: "old", Key: old, NotBefore: now.Add(-48 * time.Hour), NotAfter: now.Add(time.Hour)},
+				{ID: "current", Key: current, NotBefore: now.Add(-time.Hour)},
+			},
+			want: []*rsa.PrivateKey{current, old},
+		},
+		{
+			name: "expired and not yet valid keys are skipped",
+			keys: []StakeholderKey{
+				{ID: "old", Key: old, NotAfter: now.Add(-time.Hour)},
+				{ID: "current", Key: current, NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour)},
+				{ID: "next", Key: next, NotBefore: now.Add(time.Hour)},
+			},
+			want: []*rsa.PrivateKey{current},
+		},
+		{
+			name: "no key valid now",
+			keys: []StakeholderKey{
+				{ID: "old", Key: old, NotAfter: now.Add(-time.Hour)},
+				{ID: "next", Key: next, NotBefore: now.Add(time.Hour)},
+			},
+			wantErr: errNoActiveKey,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := activeStakeholderKeys(&staticKeyProvider{privKeys: tt.keys})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("activeStakeholderKeys error = %v, want %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("activeStakeholderKeys returned %d keys, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("key %d is not the expected one", i)
+				}
+			}
+		})
+	}
+}
+
+func TestReloadingKeyProviderKeepsKeysOnFailure(t *testing.T) {
+	first, second := testRSAKey(t), testRSAKey(t)
+
+	var next KeyProvider = &staticKeyProvider{privKeys: []StakeholderKey{{ID: "first", Key: first}}}
+	var loadErr error
+	p, err := NewReloadingKeyProvider(func() (KeyProvider, error) { return next, loadErr })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signing := func() *rsa.PrivateKey {
+		t.Helper()
+		key, err := signingKey(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	next, loadErr = nil, errors.New("truststore unreadable")
+	if err := p.Reload(); err == nil {
+		t.Fatal("Reload succeeded with a failing load")
+	}
+	if signing() != first {
+		t.Fatal("a failed reload replaced the keys")
+	}
+
+	next, loadErr = &staticKeyProvider{privKeys: []StakeholderKey{{ID: "second", Key: second}}}, nil
+	if err := p.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if signing() != second {
+		t.Fatal("a successful reload kept the old keys")
+	}
+}
+
+func TestParseStakeholderKey(t *testing.T) {
+	key := testRSAKey(t)
+	const passphrase = "correct horse"
+
+	pkcs1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	pkcs8DER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plainPKCS8 := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8DER})
+
+	encryptedDER, err := pkcs8.MarshalPrivateKey(key, []byte(passphrase), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedPKCS8 := pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: encryptedDER})
+
+	legacyBlock, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key), []byte(passphrase), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyEncrypted := pem.EncodeToMemory(legacyBlock)
+
+	certDER, err := selfSignedCertificate(key, pkix.Name{CommonName: "PESAPAL_TEST"}, time.Now(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pfx, err := pkcs12.Modern.Encode(key, cert, nil, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		bs         []byte
+		passphrase string
+		wantErr    string
+	}{
+		{name: "pkcs1", bs: pkcs1},
+		{name: "pkcs8", bs: plainPKCS8},
+		{name: "encrypted pkcs8", bs: encryptedPKCS8, passphrase: passphrase},
+		{name: "encrypted pkcs8 without passphrase", bs: encryptedPKCS8, wantErr: "no passphrase is configured"},
+		{name: "encrypted pkcs8 with wrong passphrase", bs: encryptedPKCS8, passphrase: "wrong", wantErr: "parse encrypted pkcs8 private key"},
+		{name: "legacy encrypted pem", bs: legacyEncrypted, passphrase: passphrase},
+		{name: "legacy encrypted pem without passphrase", bs: legacyEncrypted, wantErr: "no passphrase is configured"},
+		{name: "pkcs12", bs: pfx, passphrase: passphrase},
+		{name: "pkcs12 with wrong password", bs: pfx, passphrase: "wrong", wantErr: "parse pkcs12 private key"},
+		{name: "unsupported block", bs: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: []byte{0}}), wantErr: "unsupported private key PEM block"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseStakeholderKey(tt.bs, tt.passphrase)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseStakeholderKey error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(key) {
+				t.Fatal("parseStakeholderKey returned another key")
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// LogConfig configures the JSON logs written to stderr.
+type LogConfig struct {
+	// Level is debug, info, warn or error.
+	Level string `json:"level"`
+}
+
+func (lc LogConfig) Validate() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(lc.Level)); lc.Level != "" && err != nil {
+		return fmt.Errorf("log level: %w", err)
+	}
+	return nil
+}
+
+// logLevel is shared by every logger so the level can change after start up,
+// once the config is read.
+var logLevel = new(slog.LevelVar)
+
+func (lc LogConfig) apply() {
+	if lc.Level == "" {
+		return
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(lc.Level)); err == nil {
+		logLevel.Set(level)
+	}
+}
+
+// newLogger returns a JSON logger that redacts identity data and secrets.
+func newLogger(w io.Writer) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       logLevel,
+		ReplaceAttr: redactAttr,
+	}))
+}
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute keys whose values are never logged.
+var sensitiveKeys = map[string]bool{
+	"nin": true, "email": true, "phone": true, "telephone": true,
+	"firstname": true, "lastname": true, "name": true, "dob": true, "date_of_birth": true,
+	"payload": true, "envelope": true, "body": true, "crypto_info": true, "signature": true,
+	"template": true, "photo": true, "image": true,
+	"key": true, "private_key": true, "passphrase": true, "password": true, "secret": true, "token": true,
+}
+
+var sensitiveSuffixes = []string{"_key", "_secret", "_token", "_password"}
+
+func sensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	if sensitiveKeys[key] {
+		return true
+	}
+	for _, suffix := range sensitiveSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// sensitivePatterns catch identity data and key material inside free text
+// such as messages and errors.
+var sensitivePatterns = []struct {
+	re   *regexp.Regexp
+	mask string
+}{
+	{regexp.MustCompile(`-----BEGIN [A-Z0-9 ]+-----[\s\S]*?(-----END [A-Z0-9 ]+-----|$)`), "[KEY]"},
+	{regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), "[EMAIL]"},
+	// NINs are 20 digits, usually written YYYYMMDD-XXXXX-XXXXX-XX.
+	{regexp.MustCompile(`\b\d{8}-?\d{5}-?\d{5}-?\d{2}\b`), "[NIN]"},
+	{regexp.MustCompile(`(\+|\b00)\d{9,14}\b|\b0[67]\d{8}\b`), "[PHONE]"},
+}
+
+func redactString(s string) string {
+	for _, p := range sensitivePatterns {
+		s = p.re.ReplaceAllString(s, p.mask)
+	}
+	return s
+}
+
+// redactAttr is the ReplaceAttr hook of every logger.
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	if sensitiveKey(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, redactString(a.Value.String()))
+	case slog.KindAny:
+		switch v := a.Value.Any().(type) {
+		case error:
+			return slog.String(a.Key, redactString(v.Error()))
+		case fmt.Stringer:
+			return slog.String(a.Key, redactString(v.String()))
+		case []byte:
+			return slog.String(a.Key, redacted)
+		}
+	}
+
+	return a
+}
+
+type loggerKey struct{}
+
+// loggerFrom returns the request-scoped logger in ctx, or the default one.
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+const requestIDHeader = "X-Request-ID"
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestLogging gives each request an ID, taken from X-Request-ID when the
+// caller sent a sane one, and a logger carrying it and the trace ID. It logs
+// the route template rather than the URL, which may hold a NIN.
+func requestLogging(c *gin.Context) {
+	id := c.GetHeader(requestIDHeader)
+	if !validRequestID.MatchString(id) {
+		id = newRequestID()
+	}
+	c.Header(requestIDHeader, id)
+
+	logger := slog.Default().With("request_id", id)
+	if sc := trace.SpanContextFromContext(c.Request.Context()); sc.HasTraceID() {
+		logger = logger.With("trace_id", sc.TraceID().String())
+	}
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), loggerKey{}, logger))
+
+	start := time.Now()
+	c.Next()
+
+	level := slog.LevelInfo
+	if c.Writer.Status() >= 500 {
+		level = slog.LevelError
+	}
+	logger.Log(c.Request.Context(), level, "request",
+		"method", c.Request.Method,
+		"route", c.FullPath(),
+		"status", c.Writer.Status(),
+		"duration_ms", time.Since(start).Milliseconds(),
+		"client_ip", c.ClientIP(),
+		"errors", c.Errors.ByType(gin.ErrorTypePrivate).String(),
+	)
+}
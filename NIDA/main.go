@@ -1,137 +1,287 @@
 package main
 
 import (
-	"NIDA/configs"
-	"crypto/rsa"
-	"crypto/x509"
+	"context"
 	"database/sql"
-	"encoding/json"
-	"encoding/pem"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"strings"
 
 	dbase "NIDA/db"
 
 	"github.com/gin-gonic/gin"
-	"github.com/go-sql-driver/mysql"
+	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 
 
 func main() {
-	//Auto migrate tables
+	// A .env file is optional and only fills in variables that are not set.
+	godotenv.Load()
 
-	cfg := initCFG()
-	
-	db, err := dbase.NewMySQLStorage(cfg)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "ctl":
+			os.Exit(runNidactl(os.Args[2:], os.Stdout, os.Stderr))
+		case "config":
+			os.Exit(runNidactl(os.Args[1:], os.Stdout, os.Stderr))
+		}
+	}
+
+	slog.SetDefault(newLogger(os.Stderr))
+
+	src := configFlags(flag.CommandLine)
+	flag.Parse()
+
+	cfg, err := LoadConfig(src)
 	if err != nil {
-		log.Fatal(err)
+		fatal("load config", err)
 	}
-	
+	// Every log line says which gateway it concerns.
+	slog.SetDefault(slog.Default().With("profile", cfg.Profile))
+
+	db, err := dbase.NewMySQLStorage(cfg.Database.mysql())
+	if err != nil {
+		fatal("open database", err)
+	}
+
 	initStorage(db)
 
-	if err := run(); err != nil {
-		log.Fatal(err)
+	if err := run(cfg, db); err != nil {
+		fatal("run", err)
 	}
 }
 
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
+
+// initStorage checks the database at start up. An unreachable database is
+// not fatal: /readyz reports it until the database comes up.
 func initStorage (db *sql.DB) {
 	err := db.Ping()
 
 	if err != nil {
-		log.Fatal(err)
+		slog.Warn("database unreachable", "err", err)
+		return
 	}
 
-	log.Println("Successfully connected to database")
+	slog.Info("connected to database")
 }
 
-func initCFG() mysql.Config{
-	// Auto migrate tables
-	cfg := mysql.Config{
-		User:                 configs.Envs.DBUser,
-		Passwd:               configs.Envs.DBPassword,
-		Addr:                 configs.Envs.DBAddress,
-		DBName:               configs.Envs.DBName,
-		Net:                  "tcp",
-		AllowNativePasswords: true,
-		ParseTime:            true,
-	}
-	
-	return cfg
-}
+func run(cfg *Config, db *sql.DB) error {
+	cfg.Log.apply()
+	slog.Info("loaded config", "nida_url", cfg.NidaURL, "user_id", cfg.UserID)
 
-func run() error {
-	cfg, err := ReadConfig("conf.json")
+	shutdownTracing, err := setupTracing(context.Background(), cfg.Tracing)
 	if err != nil {
 		return err
 	}
+	defer shutdownTracing(context.Background())
+
+	go cfg.Keys.ReloadOnSIGHUP()
+
+	client, err := NewNidaClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	limiter := NewAPILimiter(cfg.APIRateLimit, NewMemoryRateLimitStore(), cfg.hashNIN)
+	background := NewBackground()
+	handlers := Handlers{
+		Config:     cfg,
+		Client:     client,
+		Limiter:    limiter,
+		Cache:      NewMemoryVerificationCache(cfg.VerificationCache),
+		DB:         db,
+		Background: background,
+	}
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, "nida"))
 
-	handlers := Handlers{Config: cfg}
-	router := gin.Default()
-	router.POST("/verify", verifyHandler)
-	router.POST("/verify/v2", handlers.verify)
-	router.POST("/register", registerMerchant)
-	router.POST("/email", emailHandler)
-	router.POST("/verify-answer", verifyAnswerHandler)
-	return router.Run(":8080")
+	router := gin.New()
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		return err
+	}
+	router.Use(requestTracing, requestLogging, httpMetrics, gin.Recovery())
+	api := router.Group("/", handlers.identify, limiter.Middleware())
+	api.POST("/verify", handlers.verifyHandler)
+	api.POST("/verify/v2", handlers.verify)
+	api.POST("/verify/v2/demographics", handlers.demographics)
+	api.POST("/verify/v2/photo", handlers.photo)
+	api.POST("/verify/v2/fingerprint", handlers.fingerprint)
+	api.POST("/verify/v2/name-match", handlers.nameMatch)
+	api.POST("/register", handlers.registerMerchant)
+	api.POST("/email", handlers.emailHandler)
+	api.POST("/verify-answer", handlers.verifyAnswerHandler)
+	api.DELETE("/admin/verification-cache/:merchant_id", handlers.adminOnly, handlers.invalidateVerification)
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	health := NewHealth(cfg, db, client)
+	router.GET("/healthz", health.live)
+	router.GET("/readyz", health.ready)
+	return serve(cfg.Server, router, background)
 }
 
 type Config struct {
-	UserID                string
-	NidaURL               string
-	MessageSecurityPubKey *rsa.PublicKey
-	StakeholderPrivKey    *rsa.PrivateKey
+	// Profile names the gateway in use, see profiles.go.
+	Profile string
+	UserID  string
+	NidaURL string
+	Keys    *ReloadingKeyProvider
+	Suite   CryptoSuite
+	SOAP    SOAPConfig
+	HTTP    HTTPClientConfig
+	Retry   RetryConfig
+	Breaker BreakerConfig
+	// RateLimit caps requests to the gateway.
+	RateLimit RateLimitConfig
+	// APIRateLimit limits callers of this service.
+	APIRateLimit APIRateLimitConfig
+	// VerificationCache sets how long verification outcomes are reused.
+	VerificationCache VerificationCacheConfig
+	Log               LogConfig
+	Tracing           TracingConfig
+	Health            HealthConfig
+	Server            ServerConfig
+	// NameMatch holds the name and date of birth thresholds per KYC tier.
+	NameMatch NameMatchConfig
+	Database  DatabaseConfig
+	JWT       JWTConfig
+	// AdminToken turns on the admin endpoints. They are off while it is
+	// empty.
+	AdminToken Secret
+	// NINHashKey keys the HMAC that NINs are hashed with, see hashNIN.
+	NINHashKey Secret
 }
 
-func ReadConfig(filename string) (*Config, error) {
-	bs, err := os.ReadFile(filename)
+// rawConfig is the config file layout. Environment variables and flags
+// override it setting by setting, see configSource.
+type rawConfig struct {
+	Profile  string                    `json:"profile"`
+	Profiles map[string]GatewayProfile `json:"profiles,omitempty"`
+	UserID   string                    `json:"user_id"`
+	NidaURL  string                    `json:"nida_url"`
+	keyConfig
+	CryptoSuite       CryptoSuite             `json:"crypto_suite"`
+	SOAP              SOAPConfig              `json:"soap"`
+	NameMatch         NameMatchConfig         `json:"name_match"`
+	HTTP              HTTPClientConfig        `json:"http"`
+	Retry             RetryConfig             `json:"retry"`
+	Breaker           BreakerConfig           `json:"circuit_breaker"`
+	RateLimit         RateLimitConfig         `json:"rate_limit"`
+	APIRateLimit      APIRateLimitConfig      `json:"api_rate_limit"`
+	VerificationCache VerificationCacheConfig `json:"verification_cache"`
+	Log               LogConfig               `json:"log"`
+	Tracing           TracingConfig           `json:"tracing"`
+	Health            HealthConfig            `json:"health"`
+	Server            ServerConfig            `json:"server"`
+	Database          DatabaseConfig          `json:"database"`
+	JWT               JWTConfig               `json:"jwt"`
+	AdminToken        Secret                  `json:"admin_token"`
+	NINHashKey        Secret                  `json:"nin_hash_key"`
+}
 
-	if err != nil {
-		return nil, err
-	}
+func (rc rawConfig) withDefaults() rawConfig {
+	rc.CryptoSuite = rc.CryptoSuite.withDefaults()
+	rc.SOAP = rc.SOAP.withDefaults()
+	rc.NameMatch = rc.NameMatch.withDefaults()
+	rc.HTTP = rc.HTTP.withDefaults()
+	rc.Retry = rc.Retry.withDefaults()
+	rc.Breaker = rc.Breaker.withDefaults()
+	rc.Tracing = rc.Tracing.withDefaults()
+	rc.Health = rc.Health.withDefaults()
+	rc.Server = rc.Server.withDefaults()
+	rc.Database = rc.Database.withDefaults()
+	rc.JWT = rc.JWT.withDefaults()
+	return rc
+}
 
-	var rawCfg struct {
-		UserID                string `json:"user_id"`
-		NidaURL               string `json:"nida_url"`
-		MessageSecurityPubKey string `json:"message_security_pub_key"`
-		StakeholderPrivKey    string `json:"stakeholder_priv_key"`
-	}
-	if err := json.Unmarshal(bs, &rawCfg); err != nil {
-		return nil, err
+func (rc rawConfig) Validate() error {
+	if err := rc.validateProfile(); err != nil {
+		return err
 	}
 
-	pubkeybs, err := os.ReadFile(rawCfg.MessageSecurityPubKey)
-	if err != nil {
-		return nil, err
+	if err := rc.CryptoSuite.Validate(); err != nil {
+		return fmt.Errorf("crypto suite: %w", err)
 	}
 
-	pubkeyBlock, _ := pem.Decode(pubkeybs)
-	cert, err := x509.ParseCertificate(pubkeyBlock.Bytes)
-	if err != nil {
-		return nil, fmt.Errorf("parse certificate: %w", err)
+	for _, v := range []interface{ Validate() error }{
+		rc.SOAP,
+		rc.NameMatch,
+		rc.HTTP,
+		rc.Retry,
+		rc.Breaker,
+		rc.RateLimit,
+		rc.APIRateLimit,
+		rc.Log,
+		rc.Tracing,
+		rc.Health,
+		rc.Server,
+		rc.VerificationCache,
+	} {
+		if err := v.Validate(); err != nil {
+			return err
+		}
 	}
 
-	pubKey, ok := cert.PublicKey.(*rsa.PublicKey)
-	if !ok {
-		return nil, fmt.Errorf("expected rsa public key but got %T", cert.PublicKey)
+	if !allowsInsecure(rc.Profile) {
+		if problems := rc.insecure(); len(problems) > 0 {
+			return fmt.Errorf("insecure settings in the %s profile (allowed only in %s and %s): %s",
+				rc.Profile, profileSandbox, profileSimulator, strings.Join(problems, "; "))
+		}
 	}
 
-	privkeybs, err := os.ReadFile(rawCfg.StakeholderPrivKey)
+	return nil
+}
+
+// LoadConfig loads and validates the config from src. Keys are read again
+// from src when they are reloaded.
+func LoadConfig(src *configSource) (*Config, error) {
+	rc, err := src.load()
 	if err != nil {
 		return nil, err
 	}
+	if err := rc.Validate(); err != nil {
+		return nil, err
+	}
 
-	privkeyBlock, _ := pem.Decode(privkeybs)
-	privKey, err := x509.ParsePKCS1PrivateKey(privkeyBlock.Bytes)
+	keys, err := NewReloadingKeyProvider(func() (KeyProvider, error) {
+		rc, err := src.load()
+		if err != nil {
+			return nil, err
+		}
+		return rc.keyConfig.keyProvider()
+	})
 	if err != nil {
-		return nil, fmt.Errorf("expected rsa private key but got %T", cert.PublicKey)
+		return nil, fmt.Errorf("load keys: %w", err)
 	}
 
 	return &Config{
-		UserID:                rawCfg.UserID,
-		NidaURL:               rawCfg.NidaURL,
-		MessageSecurityPubKey: pubKey,
-		StakeholderPrivKey:    privKey,
+		Profile:           rc.Profile,
+		UserID:            rc.UserID,
+		NidaURL:           rc.NidaURL,
+		Keys:              keys,
+		Suite:             rc.CryptoSuite,
+		SOAP:              rc.SOAP,
+		HTTP:              rc.HTTP,
+		Retry:             rc.Retry,
+		Breaker:           rc.Breaker,
+		RateLimit:         rc.RateLimit,
+		APIRateLimit:      rc.APIRateLimit,
+		VerificationCache: rc.VerificationCache,
+		Log:               rc.Log,
+		Tracing:           rc.Tracing,
+		Health:            rc.Health,
+		Server:            rc.Server,
+		NameMatch:         rc.NameMatch,
+		Database:          rc.Database,
+		JWT:               rc.JWT,
+		AdminToken:        rc.AdminToken,
+		NINHashKey:        rc.NINHashKey,
 	}, nil
 }
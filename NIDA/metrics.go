@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var certificateExpiryTimestamp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "nida",
+	Name:      "certificate_expiry_timestamp_seconds",
+	Help:      "When the NIDA message security certificate expires, in Unix seconds.",
+}, []string{"key_id"})
+
+var rateLimitWaitSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "nida",
+	Name:      "rate_limit_wait_seconds",
+	Help:      "Time gateway calls spent waiting for the client-side rate limiter.",
+	Buckets:   []float64{0.001, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+}, []string{"operation"})
+
+var httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "nida",
+	Name:      "http_requests_total",
+	Help:      "API requests served, by route template and status code.",
+}, []string{"method", "route", "status"})
+
+var httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "nida",
+	Name:      "http_request_duration_seconds",
+	Help:      "Time taken to serve API requests, by route template.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"method", "route"})
+
+// httpMetrics records every request against its route template; requests
+// that match no route share the "unmatched" route so that scanners cannot
+// add series.
+func httpMetrics(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	httpRequestsTotal.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+	httpRequestDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+}
+
+var gatewayCallsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "nida",
+	Name:      "gateway_calls_total",
+	Help:      "Calls to the NIDA gateway, by operation, HTTP status code and outcome.",
+}, []string{"operation", "status_code", "outcome"})
+
+var gatewayCallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "nida",
+	Name:      "gateway_call_duration_seconds",
+	Help:      "Round trip time of calls to the NIDA gateway, by operation and outcome.",
+	Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30},
+}, []string{"operation", "outcome"})
+
+// gatewayOutcome classifies the error of a gateway call for metrics.
+func gatewayOutcome(err error) string {
+	var (
+		fault  *SOAPFault
+		status *GatewayStatusError
+		open   *CircuitOpenError
+	)
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.As(err, &fault):
+		return "fault"
+	case errors.As(err, &status):
+		return "http_error"
+	case errors.As(err, &open):
+		return "circuit_open"
+	case errors.Is(err, errRateLimited):
+		return "rate_limited"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	default:
+		return "error"
+	}
+}
+
+// countGatewayCall counts a call that got as far as statusCode, which is
+// zero when no response arrived.
+func countGatewayCall(operation string, statusCode int, err error) {
+	code := "none"
+	if statusCode != 0 {
+		code = strconv.Itoa(statusCode)
+	}
+	gatewayCallsTotal.WithLabelValues(operation, code, gatewayOutcome(err)).Inc()
+}
+
+// observeGatewayCall counts a call that was sent at start.
+func observeGatewayCall(operation string, start time.Time, statusCode int, err error) {
+	countGatewayCall(operation, statusCode, err)
+	gatewayCallDuration.WithLabelValues(operation, gatewayOutcome(err)).Observe(time.Since(start).Seconds())
+}
+
+var emailsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "nida",
+	Name:      "emails_total",
+	Help:      "Verification emails, by outcome: sent, no_merchant, smtp_error or error.",
+}, []string{"outcome"})
+
+// Stages of the onboarding funnel, in the order a merchant reaches them.
+const (
+	funnelRegistered            = "registered"
+	funnelQuestionIssued        = "question_issued"
+	funnelAnswerSubmitted       = "answer_submitted"
+	funnelAnswerChecked         = "answer_checked"
+	funnelFingerprintMatched    = "fingerprint_matched"
+	funnelFingerprintNotMatched = "fingerprint_not_matched"
+)
+
+var verificationFunnelTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "nida",
+	Name:      "verification_funnel_total",
+	Help:      "Merchants reaching each stage of onboarding verification.",
+}, []string{"stage"})
+
+func init() {
+	// Start every stage at zero so that ratios between stages are defined
+	// before the first merchant reaches them.
+	stages := []string{funnelRegistered, funnelQuestionIssued, funnelAnswerSubmitted, funnelAnswerChecked, funnelFingerprintMatched, funnelFingerprintNotMatched}
+	for _, verdict := range []MatchVerdict{VerdictMatch, VerdictReview, VerdictNoMatch} {
+		stages = append(stages, nameMatchStage(verdict))
+	}
+	for _, stage := range stages {
+		verificationFunnelTotal.WithLabelValues(stage)
+	}
+}
+
+func nameMatchStage(verdict MatchVerdict) string {
+	return "name_match_" + string(verdict)
+}
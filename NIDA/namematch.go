@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+type MatchVerdict string
+
+const (
+	VerdictMatch   MatchVerdict = "match"
+	VerdictReview  MatchVerdict = "review"
+	VerdictNoMatch MatchVerdict = "no_match"
+)
+
+// MatchThresholds decide the verdict for one KYC tier.
+type MatchThresholds struct {
+	// Match is the lowest name score accepted without review.
+	Match float64 `json:"match"`
+	// Review is the lowest name score sent to manual review rather than
+	// rejected.
+	Review float64 `json:"review"`
+	// RequireDOB refuses a match unless the date of birth agrees exactly.
+	RequireDOB bool `json:"require_dob"`
+}
+
+// NameMatchConfig holds the thresholds of each KYC tier, keyed by tier name.
+type NameMatchConfig struct {
+	Tiers map[string]MatchThresholds `json:"tiers"`
+}
+
+var defaultNameMatchConfig = NameMatchConfig{
+	Tiers: map[string]MatchThresholds{
+		"basic":    {Match: 0.85, Review: 0.70},
+		"enhanced": {Match: 0.92, Review: 0.80, RequireDOB: true},
+	},
+}
+
+func (nc NameMatchConfig) withDefaults() NameMatchConfig {
+	if len(nc.Tiers) == 0 {
+		return defaultNameMatchConfig
+	}
+	return nc
+}
+
+func (nc NameMatchConfig) Validate() error {
+	for tier, t := range nc.Tiers {
+		if t.Review < 0 || t.Review > t.Match || t.Match > 1 {
+			return fmt.Errorf("name match tier %q: need 0 <= review <= match <= 1", tier)
+		}
+	}
+	return nil
+}
+
+// NameMatchResult is the outcome of comparing what a merchant submitted with
+// the demographics NIDA holds for their NIN.
+type NameMatchResult struct {
+	Tier      string  `json:"tier"`
+	NameScore float64 `json:"name_score"`
+	// DOBScore is 1 for the same date, 0.5 for day and month swapped and 0
+	// otherwise. It is nil when either side has no date of birth.
+	DOBScore *float64     `json:"dob_score,omitempty"`
+	Verdict  MatchVerdict `json:"verdict"`
+}
+
+// nameParticles are honorifics and connectors that carry no identity, e.g.
+// the "bin" in "Ali bin Hassan" or the "Bi" in "Bi Mwanaisha".
+var nameParticles = map[string]bool{
+	"bw": true, "bwana": true, "bi": true, "mzee": true,
+	"bin": true, "binti": true, "bint": true, "wa": true,
+	"mr": true, "mrs": true, "ms": true, "miss": true, "dr": true, "prof": true,
+}
+
+var stripMarks = transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+// normalizeName splits a name into comparable tokens. It folds case and
+// diacritics, drops apostrophes (Ng'wana), punctuation and particles, and
+// collapses doubled letters so Mohammed and Mohamed compare equal.
+func normalizeName(name string) []string {
+	folded, _, err := transform.String(stripMarks, strings.ToLower(name))
+	if err != nil {
+		folded = strings.ToLower(name)
+	}
+
+	var tokens []string
+	for _, field := range strings.FieldsFunc(folded, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '-' || r == '.' || r == ','
+	}) {
+		var b strings.Builder
+		var last rune
+		for _, r := range field {
+			if !unicode.IsLetter(r) || r == last {
+				continue
+			}
+			b.WriteRune(r)
+			last = r
+		}
+
+		token := b.String()
+		if token == "" || nameParticles[token] {
+			continue
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens
+}
+
+// jaroWinkler returns the Jaro-Winkler similarity of a and b in [0, 1].
+func jaroWinkler(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 || len(rb) == 0 {
+		if len(ra) == len(rb) {
+			return 1
+		}
+		return 0
+	}
+
+	window := max(len(ra), len(rb))/2 - 1
+	window = max(window, 0)
+
+	matchedA := make([]bool, len(ra))
+	matchedB := make([]bool, len(rb))
+	matches := 0
+	for i := range ra {
+		lo, hi := max(0, i-window), min(len(rb), i+window+1)
+		for j := lo; j < hi; j++ {
+			if matchedB[j] || ra[i] != rb[j] {
+				continue
+			}
+			matchedA[i], matchedB[j] = true, true
+			matches++
+			break
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+
+	transpositions := 0
+	j := 0
+	for i := range ra {
+		if !matchedA[i] {
+			continue
+		}
+		for !matchedB[j] {
+			j++
+		}
+		if ra[i] != rb[j] {
+			transpositions++
+		}
+		j++
+	}
+
+	m := float64(matches)
+	jaro := (m/float64(len(ra)) + m/float64(len(rb)) + (m-float64(transpositions)/2)/m) / 3
+
+	prefix := 0
+	for prefix < min(4, len(ra), len(rb)) && ra[prefix] == rb[prefix] {
+		prefix++
+	}
+
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
+
+// tokenScore compares two name tokens, accepting an initial for a name.
+func tokenScore(submitted, registered string) float64 {
+	if len([]rune(submitted)) == 1 {
+		if strings.HasPrefix(registered, submitted) {
+			return 0.85
+		}
+		return 0
+	}
+	return jaroWinkler(submitted, registered)
+}
+
+// nameScore compares the submitted names with the registered ones
+// regardless of order. Every submitted token is paired with its best
+// unused registered token; registered middle names left unpaired do not
+// count against the score, but the registered surname has to be present.
+func nameScore(submitted, registered []string, surname string) float64 {
+	if len(submitted) == 0 || len(registered) == 0 {
+		return 0
+	}
+
+	used := make([]bool, len(registered))
+	total := 0.0
+	for _, s := range submitted {
+		best, bestIdx := 0.0, -1
+		for i, r := range registered {
+			if used[i] {
+				continue
+			}
+			if score := tokenScore(s, r); score > best {
+				best, bestIdx = score, i
+			}
+		}
+		if bestIdx >= 0 {
+			used[bestIdx] = true
+		}
+		total += best
+	}
+	score := total / float64(len(submitted))
+
+	surnameTokens := normalizeName(surname)
+	for _, st := range surnameTokens {
+		best := 0.0
+		for _, s := range submitted {
+			best = max(best, tokenScore(s, st))
+		}
+		score = min(score, best)
+	}
+
+	return score
+}
+
+var dobLayouts = []string{"2006-01-02", "02/01/2006", "20060102", time.RFC3339}
+
+func parseDOB(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range dobLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func dobScore(submitted, registered string) (float64, bool) {
+	a, okA := parseDOB(submitted)
+	b, okB := parseDOB(registered)
+	if !okA || !okB {
+		return 0, false
+	}
+
+	switch {
+	case a.Year() == b.Year() && a.Month() == b.Month() && a.Day() == b.Day():
+		return 1, true
+	case a.Year() == b.Year() && int(a.Month()) == b.Day() && a.Day() == int(b.Month()):
+		return 0.5, true
+	default:
+		return 0, true
+	}
+}
+
+// matchMerchant compares a merchant's submitted names and date of birth with
+// NIDA's demographics and gives a verdict under the thresholds of tier.
+func (nc NameMatchConfig) matchMerchant(tier string, merchant Merchant, d *Demographics) (NameMatchResult, error) {
+	t, ok := nc.Tiers[tier]
+	if !ok {
+		return NameMatchResult{}, fmt.Errorf("unknown kyc tier %q", tier)
+	}
+
+	submitted := normalizeName(merchant.FirstName + " " + merchant.LastName)
+	registered := normalizeName(strings.Join([]string{d.FirstName, d.MiddleName, d.OtherNames, d.Surname}, " "))
+
+	result := NameMatchResult{
+		Tier:      tier,
+		NameScore: nameScore(submitted, registered, d.Surname),
+	}
+
+	dob, haveDOB := dobScore(merchant.DateOfBirth, d.DateOfBirth)
+	if haveDOB {
+		result.DOBScore = &dob
+	}
+
+	switch {
+	case result.NameScore >= t.Match:
+		result.Verdict = VerdictMatch
+	case result.NameScore >= t.Review:
+		result.Verdict = VerdictReview
+	default:
+		result.Verdict = VerdictNoMatch
+	}
+
+	// A date of birth that does not agree exactly never passes without
+	// review, and rejects outright where the tier requires it.
+	dobAgrees := haveDOB && dob == 1
+	switch {
+	case t.RequireDOB && haveDOB && dob == 0:
+		result.Verdict = VerdictNoMatch
+	case result.Verdict == VerdictMatch && !dobAgrees && (haveDOB || t.RequireDOB):
+		result.Verdict = VerdictReview
+	}
+
+	return result, nil
+}
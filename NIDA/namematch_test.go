@@ -0,0 +1,187 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"Juma Hamisi", []string{"juma", "hamisi"}},
+		{"  JUMA   hamisi ", []string{"juma", "hamisi"}},
+		{"Zoë Nyerère", []string{"zoe", "nyerere"}},
+		{"Ng'wana Kija", []string{"ngwana", "kija"}},
+		{"Mohammed", []string{"mohamed"}},
+		{"Ali bin Hassan", []string{"ali", "hasan"}},
+		{"Bi Mwanaisha Salim-Omar", []string{"mwanaisha", "salim", "omar"}},
+		{"Dr. J. Kikwete", []string{"j", "kikwete"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeName(tt.name); !slices.Equal(got, tt.want) {
+				t.Fatalf("normalizeName(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJaroWinkler(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		// Reference values from Winkler's paper.
+		{"martha", "marhta", 0.961},
+		{"dwayne", "duane", 0.840},
+		{"dixon", "dicksonx", 0.813},
+		// Three characters out of order count as one and a half
+		// transpositions, not one.
+		{"fatuma", "fuatam", 0.875},
+		{"juma", "juma", 1},
+		{"juma", "", 0},
+		{"", "", 1},
+		{"abc", "xyz", 0},
+	}
+	for _, tt := range tests {
+		if got := jaroWinkler(tt.a, tt.b); math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("jaroWinkler(%q, %q) = %.3f, want %.3f", tt.a, tt.b, got, tt.want)
+		}
+		if got, swapped := jaroWinkler(tt.a, tt.b), jaroWinkler(tt.b, tt.a); got != swapped {
+			t.Errorf("jaroWinkler is not symmetric for %q and %q: %v, %v", tt.a, tt.b, got, swapped)
+		}
+	}
+}
+
+func TestMatchMerchant(t *testing.T) {
+	registered := &Demographics{
+		FirstName:   "Amina",
+		MiddleName:  "Juma",
+		Surname:     "Mwakyusa",
+		DateOfBirth: "1990-03-07",
+	}
+
+	tests := []struct {
+		name        string
+		tier        string
+		merchant    Merchant
+		wantName    float64
+		wantDOB     *float64
+		wantVerdict MatchVerdict
+	}{
+		{
+			name:        "exact",
+			tier:        "basic",
+			merchant:    Merchant{FirstName: "Amina", LastName: "Mwakyusa", DateOfBirth: "1990-03-07"},
+			wantName:    1,
+			wantDOB:     ptr(1.0),
+			wantVerdict: VerdictMatch,
+		},
+		{
+			name:        "surname first",
+			tier:        "basic",
+			merchant:    Merchant{FirstName: "Mwakyusa", LastName: "Amina", DateOfBirth: "1990-03-07"},
+			wantName:    1,
+			wantDOB:     ptr(1.0),
+			wantVerdict: VerdictMatch,
+		},
+		{
+			name:        "diacritics and case",
+			tier:        "basic",
+			merchant:    Merchant{FirstName: "AMÍNA", LastName: "mwakyusa", DateOfBirth: "07/03/1990"},
+			wantName:    1,
+			wantDOB:     ptr(1.0),
+			wantVerdict: VerdictMatch,
+		},
+		{
+			name:        "initial",
+			tier:        "basic",
+			merchant:    Merchant{FirstName: "A.", LastName: "Mwakyusa", DateOfBirth: "1990-03-07"},
+			wantName:    0.925,
+			wantDOB:     ptr(1.0),
+			wantVerdict: VerdictMatch,
+		},
+		{
+			name:        "day and month swapped",
+			tier:        "basic",
+			merchant:    Merchant{FirstName: "Amina", LastName: "Mwakyusa", DateOfBirth: "1990-07-03"},
+			wantName:    1,
+			wantDOB:     ptr(0.5),
+			wantVerdict: VerdictReview,
+		},
+		{
+			name:        "day and month swapped, dob required",
+			tier:        "enhanced",
+			merchant:    Merchant{FirstName: "Amina", LastName: "Mwakyusa", DateOfBirth: "1990-07-03"},
+			wantName:    1,
+			wantDOB:     ptr(0.5),
+			wantVerdict: VerdictReview,
+		},
+		{
+			name:        "wrong dob, dob required",
+			tier:        "enhanced",
+			merchant:    Merchant{FirstName: "Amina", LastName: "Mwakyusa", DateOfBirth: "1985-01-01"},
+			wantName:    1,
+			wantDOB:     ptr(0.0),
+			wantVerdict: VerdictNoMatch,
+		},
+		{
+			name:        "no dob, dob required",
+			tier:        "enhanced",
+			merchant:    Merchant{FirstName: "Amina", LastName: "Mwakyusa"},
+			wantName:    1,
+			wantVerdict: VerdictReview,
+		},
+		{
+			name:        "no dob",
+			tier:        "basic",
+			merchant:    Merchant{FirstName: "Amina", LastName: "Mwakyusa"},
+			wantName:    1,
+			wantVerdict: VerdictMatch,
+		},
+		{
+			name:        "surname missing",
+			tier:        "basic",
+			merchant:    Merchant{FirstName: "Amina", LastName: "Juma", DateOfBirth: "1990-03-07"},
+			wantName:    0.583,
+			wantDOB:     ptr(1.0),
+			wantVerdict: VerdictNoMatch,
+		},
+		{
+			name:        "someone else",
+			tier:        "basic",
+			merchant:    Merchant{FirstName: "Baraka", LastName: "Shirima", DateOfBirth: "1990-03-07"},
+			wantName:    0.593,
+			wantDOB:     ptr(1.0),
+			wantVerdict: VerdictNoMatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := defaultNameMatchConfig.matchMerchant(tt.tier, tt.merchant, registered)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(result.NameScore-tt.wantName) > 0.001 {
+				t.Errorf("name score = %.3f, want %.3f", result.NameScore, tt.wantName)
+			}
+			switch {
+			case tt.wantDOB == nil && result.DOBScore != nil:
+				t.Errorf("dob score = %v, want none", *result.DOBScore)
+			case tt.wantDOB != nil && (result.DOBScore == nil || *result.DOBScore != *tt.wantDOB):
+				t.Errorf("dob score = %v, want %v", result.DOBScore, *tt.wantDOB)
+			}
+			if result.Verdict != tt.wantVerdict {
+				t.Errorf("verdict = %s, want %s", result.Verdict, tt.wantVerdict)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -1,76 +1,60 @@
 package main
 
 import (
-	dbase "NIDA/db"
-	"bytes"
-	"encoding/xml"
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
-	"net/http"
 	"net/smtp"
+	"net/url"
+	"strings"
 	"time"
 )
 
-type ResponseHeader struct {
-	Id        string    `xml:"Id"`
-	TimeStamp time.Time `xml:"TimeStamp"`
-}
-
-type ResponseBody struct {
-	CryptoInfo ResponseCryptoInfo `json:"cryptInfo" xml:"CryptoInfo"`
-	Payload    string             `json:"payload" xml:"Payload"`
-	Signature  string             `json:"signature" xml:"Signature"`
-}
-
-type StatusSectionBase struct {
-	Code int `xml:"Code"`
-}
-
-type RQVerificationResult struct {
-	XMLName xml.Name         `xml:"RQVerificationResult"`
-	Header  ResponseHeader   `xml:"Header"`
-	Body    ResponseBody     `xml:"Body"`
-	Status  StatusSectionBase `xml:"Status"`
-}
-
 type Question struct {
 	NIN      string `json:"nin" binding:"required"`
 	Question string `json:"question" binding:"required"`
 }
 
 
-func retrieveMerchantDetails(nin string) (Merchant, error) {
-	// Retrieve cfg database configuration
-	cfg := initCFG()
-
-	db, err := dbase.NewMySQLStorage(cfg)
-	if err != nil {
-		return Merchant{}, err
-	}
-	defer db.Close()
-
+func retrieveMerchantDetails(ctx context.Context, db *sql.DB, nin string) (Merchant, error) {
 	// Query to get merchant details
-	query := "SELECT merchant_name, merchant_id, email FROM merchants WHERE nin = ?"
-	var name, id, email string
-	err = db.QueryRow(query, nin).Scan(&name, &id, &email)
+	query := "SELECT firstName, email FROM merchants WHERE NIN = ?"
+	ctx, span := startDBSpan(ctx, "SELECT", "merchants", query)
+	merchant := Merchant{NIN: nin}
+	err := db.QueryRowContext(ctx, query, nin).Scan(&merchant.FirstName, &merchant.Email)
+	endSpan(span, err)
 	if err != nil {
 		return Merchant{}, err
 	}
 
-	return Merchant{FirstName: name, LastName: "LastName", Telephone: "Telephone", NIN: id, Email: email}, nil
+	return merchant, nil
 }
 
-func emailTrigger(nin string) {
+// emailTrigger sends the merchant registered with nin a verification link
+// on publicHost, the URL callers reach the service at.
+// The outcome is counted in nida_emails_total.
+func emailTrigger(ctx context.Context, db *sql.DB, publicHost, nin string) {
+	logger := loggerFrom(ctx)
+
 	// Retrieve merchant details
-	merchant, err := retrieveMerchantDetails(nin)
+	merchant, err := retrieveMerchantDetails(ctx, db, nin)
+	if errors.Is(err, sql.ErrNoRows) {
+		emailsTotal.WithLabelValues("no_merchant").Inc()
+		logger.Warn("no merchant for verification email")
+		return
+	}
 	if err != nil {
-		fmt.Println(err)
+		emailsTotal.WithLabelValues("error").Inc()
+		logger.Error("retrieve merchant for verification email", "err", err)
 		return
 	}
 
 	// Generate a unique token
 	token, err := generateToken()
 	if err != nil {
-		fmt.Println(err)
+		emailsTotal.WithLabelValues("error").Inc()
+		logger.Error("generate verification token", "err", err)
 		return
 	}
 
@@ -85,7 +69,7 @@ func emailTrigger(nin string) {
 	smtpPort := "587"
 
 	// Create the link
-	link := fmt.Sprintf("https://yourdomain.com/verify?token=%s", token)
+	link := strings.TrimSuffix(publicHost, "/") + "/verify?token=" + url.QueryEscape(token)
 	message := []byte(fmt.Sprintf("Subject: Notification\n\nHello %s,\n\nPlease click the link below to verify:\n\n%s\n\nThis link will expire in 10 minutes.", merchant.FirstName, link))
 
 	auth := smtp.PlainAuth("", from, password, smtpHost)
@@ -93,93 +77,11 @@ func emailTrigger(nin string) {
 	// Send email
 	err = smtp.SendMail(smtpHost+":"+smtpPort, auth, from, to, message)
 	if err != nil {
-		fmt.Println(err)
+		emailsTotal.WithLabelValues("smtp_error").Inc()
+		logger.Error("send verification email", "err", err)
 		return
 	}
 
-	fmt.Println("Email sent successfully to", merchant.Email)
-}
-
-func requestQuestionFromNIDA(r *http.Request, nin string) (RQVerificationResult, error) {
-	// Create the XML payload
-	requestPayload := fmt.Sprintf(`<soap:Envelope xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema" xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">
-		<soap:Header>
-			<Id></Id>
-			<TimeStamp>%s</TimeStamp>
-			<ClientNameorIP>%s</ClientNameorIP>
-			<UserID>%s</UserID>
-		</soap:Header>
-		<soap:Body>
-			<CryptoInfo>
-				<EncryptedCryptoKey>%s</EncryptedCryptoKey>
-				<EncryptedCryptoIV>%s</EncryptedCryptoIV>
-			</CryptoInfo>
-			<Payload>
-				%s
-			</Payload>
-			<Signature>%s</Signature>
-		</soap:Body>
-	</soap:Envelope>`, time.Now().Format(time.RFC3339), r.RemoteAddr, "UserID", "EncryptedCryptoKey", "EncryptedCryptoIV", nin, "Signature")
-
-	// NIDA API endpoint
-	resp, err := http.Post("https://nacer01/TZ_CIG/GatewayService.svc?wsdl", "text/xml", bytes.NewBufferString(requestPayload))
-	if err != nil {
-		return RQVerificationResult{}, err
-	}
-	defer resp.Body.Close()
-
-	// Parse the response
-	var responseEnvelope struct {
-		Body struct {
-			Response RQVerificationResult `xml:"RQVerificationResult"`
-		} `xml:"Body"`
-	}
-	if err := xml.NewDecoder(resp.Body).Decode(&responseEnvelope); err != nil {
-		return RQVerificationResult{}, err
-	}
-
-	return responseEnvelope.Body.Response, nil
-}
-
-func verifyAnswerWithNIDA(nin, rqCode, answer string) (RQVerificationResult, error) {
-	// Create the XML payload
-	requestPayload := fmt.Sprintf(`<soap:Envelope xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema" xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">
-		<soap:Header>
-			<Id></Id>
-			<TimeStamp>%s</TimeStamp>
-			<ClientNameorIP>%s</ClientNameorIP>
-			<UserID>%s</UserID>
-		</soap:Header>
-		<soap:Body>
-			<CryptoInfo>
-				<EncryptedCryptoKey>%s</EncryptedCryptoKey>
-				<EncryptedCryptoIV>%s</EncryptedCryptoIV>
-			</CryptoInfo>
-			<Payload>
-				<NIN>%s</NIN>
-				<RQCode>%s</RQCode>
-				<QNANSW>%s</QNANSW>
-			</Payload>
-			<Signature>%s</Signature>
-		</soap:Body>
-	</soap:Envelope>`, time.Now().Format(time.RFC3339), "ClientIP", "UserID", "EncryptedCryptoKey", "EncryptedCryptoIV", nin, rqCode, answer, "Signature")
-
-	// Send the request to NIDA
-	resp, err := http.Post("https://nacer01/TZ_CIG/GatewayService.svc?wsdl", "text/xml", bytes.NewBufferString(requestPayload))
-	if err != nil {
-		return RQVerificationResult{}, err
-	}
-	defer resp.Body.Close()
-
-	// Parse the response
-	var responseEnvelope struct {
-		Body struct {
-			Response RQVerificationResult `xml:"RQVerificationResult"`
-		} `xml:"Body"`
-	}
-	if err := xml.NewDecoder(resp.Body).Decode(&responseEnvelope); err != nil {
-		return RQVerificationResult{}, err
-	}
-
-	return responseEnvelope.Body.Response, nil
+	emailsTotal.WithLabelValues("sent").Inc()
+	logger.Info("verification email sent", "email", merchant.Email)
 }
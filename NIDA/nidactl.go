@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const nidactlUsage = `usage: nida ctl <command> [flags]
+
+commands:
+  envelope  build and sign a request envelope for a NIN
+  decrypt   verify and decrypt a captured SoapResponse XML file
+  cert      inspect a certificate
+  keygen    generate a stakeholder keypair and CSR, or a self-signed
+            development keypair
+  config    print the effective configuration with secrets masked
+`
+
+// runNidactl implements the nida ctl debugging subcommands. nida config is
+// a shorthand for nida ctl config.
+func runNidactl(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, nidactlUsage)
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "envelope":
+		err = nidactlEnvelope(args[1:], stdout)
+	case "decrypt":
+		err = nidactlDecrypt(args[1:], stdout)
+	case "cert":
+		err = nidactlCert(args[1:], stdout)
+	case "keygen":
+		err = nidactlKeygen(args[1:], stdout)
+	case "config":
+		err = nidactlConfig(args[1:], stdout)
+	case "help", "-h", "--help":
+		fmt.Fprint(stdout, nidactlUsage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "nida ctl: unknown command %q\n\n%s", args[0], nidactlUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintln(stderr, "nida ctl:", err)
+		return 1
+	}
+
+	return 0
+}
+
+func nidactlEnvelope(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("envelope", flag.ContinueOnError)
+	src := configFlags(fs)
+	nin := fs.String("nin", "", "NIN to put in the payload")
+	client := fs.String("client", "nidactl", "ClientNameorIP header value")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *nin == "" {
+		return fmt.Errorf("envelope: -nin is required")
+	}
+
+	cfg, err := LoadConfig(src)
+	if err != nil {
+		return err
+	}
+
+	req, err := newSoapRequest(context.Background(), cfg, *client, &RQVerificationRequest{NIN: *nin})
+	if err != nil {
+		return err
+	}
+
+	bs, err := xml.MarshalIndent(req, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(stdout, string(bs))
+	return err
+}
+
+func nidactlDecrypt(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	src := configFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("decrypt: expected one response file")
+	}
+
+	cfg, err := LoadConfig(src)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	resp, err := decodeSoapResponse(f)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Id:        %s\nTimeStamp: %s\nUserID:    %s\n", resp.Header.Id, resp.Header.Timestamp.Format(time.RFC3339), resp.Header.UserID)
+
+	payload, err := resp.Payload(context.Background(), cfg)
+	if err != nil {
+		return fmt.Errorf("verify and decrypt payload: %w", err)
+	}
+
+	_, err = fmt.Fprintf(stdout, "Payload:\n%s\n", payload)
+	return err
+}
+
+func nidactlCert(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("cert", flag.ContinueOnError)
+	caBundle := fs.String("ca", "", "CA bundle to verify the certificate against")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("cert: expected one certificate file")
+	}
+
+	bs, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	cert, err := parseCertificate(bs)
+	if err != nil {
+		return err
+	}
+
+	fingerprint := sha256.Sum256(cert.Raw)
+	fmt.Fprintf(stdout, "Subject:     %s\n", cert.Subject)
+	fmt.Fprintf(stdout, "Issuer:      %s\n", cert.Issuer)
+	fmt.Fprintf(stdout, "Serial:      %s\n", cert.SerialNumber.Text(16))
+	fmt.Fprintf(stdout, "Not before:  %s\n", cert.NotBefore.Format(time.RFC3339))
+	fmt.Fprintf(stdout, "Not after:   %s (%.0f days)\n", cert.NotAfter.Format(time.RFC3339), time.Until(cert.NotAfter).Hours()/24)
+	fmt.Fprintf(stdout, "Key usage:   %s\n", describeKeyUsage(cert.KeyUsage))
+	fmt.Fprintf(stdout, "Public key:  %s\n", describePublicKey(cert.PublicKey))
+	fmt.Fprintf(stdout, "SHA-256:     %s\n", hex.EncodeToString(fingerprint[:]))
+
+	var roots *x509.CertPool
+	if *caBundle != "" {
+		if roots, err = readCABundle(*caBundle); err != nil {
+			return err
+		}
+	}
+
+	if err := validateCertificate(cert, roots, time.Now()); err != nil {
+		fmt.Fprintf(stdout, "Valid:       no (%v)\n", err)
+		return nil
+	}
+
+	_, err = fmt.Fprintln(stdout, "Valid:       yes")
+	return err
+}
+
+func describeKeyUsage(usage x509.KeyUsage) string {
+	names := []struct {
+		usage x509.KeyUsage
+		name  string
+	}{
+		{x509.KeyUsageDigitalSignature, "digital signature"},
+		{x509.KeyUsageContentCommitment, "content commitment"},
+		{x509.KeyUsageKeyEncipherment, "key encipherment"},
+		{x509.KeyUsageDataEncipherment, "data encipherment"},
+		{x509.KeyUsageKeyAgreement, "key agreement"},
+		{x509.KeyUsageCertSign, "cert sign"},
+		{x509.KeyUsageCRLSign, "crl sign"},
+	}
+
+	var out []string
+	for _, n := range names {
+		if usage&n.usage != 0 {
+			out = append(out, n.name)
+		}
+	}
+	if len(out) == 0 {
+		return "unrestricted"
+	}
+
+	return strings.Join(out, ", ")
+}
+
+func describePublicKey(key any) string {
+	if pub, ok := key.(*rsa.PublicKey); ok {
+		return fmt.Sprintf("RSA %d bits", pub.N.BitLen())
+	}
+
+	return fmt.Sprintf("%T", key)
+}
+
+// nidactlKeygen writes a new RSA key with a CSR for NIDA to sign or, with
+// -self-signed, a certificate for the development profiles, which have no
+// NIDA issued keys.
+func nidactlKeygen(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("keygen", flag.ContinueOnError)
+	out := fs.String("out", "", "output path prefix, writes <out>.key and <out>.csr, or <out>.cer with -self-signed")
+	bits := fs.Int("bits", 2048, "RSA key size")
+	commonName := fs.String("cn", "", "subject common name, usually the NIDA user ID")
+	org := fs.String("o", "", "subject organization")
+	country := fs.String("c", "TZ", "subject country")
+	selfSigned := fs.Bool("self-signed", false, "write a self-signed certificate instead of a CSR, for development")
+	days := fs.Int("days", 365, "validity of the self-signed certificate in days")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *out == "" || *commonName == "" {
+		return fmt.Errorf("keygen: -out and -cn are required")
+	}
+	if err := os.MkdirAll(filepath.Dir(*out), 0o700); err != nil {
+		return err
+	}
+
+	privKey, err := rsa.GenerateKey(rand.Reader, *bits)
+	if err != nil {
+		return err
+	}
+
+	subject := pkix.Name{CommonName: *commonName, Country: []string{*country}}
+	if *org != "" {
+		subject.Organization = []string{*org}
+	}
+
+	keyFile := *out + ".key"
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privKey)})
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		return err
+	}
+
+	if *selfSigned {
+		certFile := *out + ".cer"
+		cert, err := selfSignedCertificate(privKey, subject, time.Now(), time.Duration(*days)*24*time.Hour)
+		if err != nil {
+			return err
+		}
+		certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
+		if err := os.WriteFile(certFile, certPEM, 0o644); err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(stdout, "Wrote %s and %s\n", keyFile, certFile)
+		return err
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:            subject,
+		SignatureAlgorithm: x509.SHA256WithRSA,
+	}, privKey)
+	if err != nil {
+		return err
+	}
+
+	csrFile := *out + ".csr"
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})
+	if err := os.WriteFile(csrFile, csrPEM, 0o644); err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(stdout, "Wrote %s and %s\n", keyFile, csrFile)
+	return err
+}
+
+// selfSignedCertificate returns a DER certificate for privKey, valid from now
+// for validity, with the key usages validateCertificate requires.
+func selfSignedCertificate(privKey *rsa.PrivateKey, subject pkix.Name, now time.Time, validity time.Duration) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:       serial,
+		Subject:            subject,
+		NotBefore:          now.Add(-time.Hour),
+		NotAfter:           now.Add(validity),
+		KeyUsage:           x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		SignatureAlgorithm: x509.SHA256WithRSA,
+	}
+
+	return x509.CreateCertificate(rand.Reader, template, template, &privKey.PublicKey, privKey)
+}
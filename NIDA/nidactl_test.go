@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestDevelopmentProfilesLoad runs the steps of make dev-keys and loads
+// conf.json with each development profile.
+func TestDevelopmentProfilesLoad(t *testing.T) {
+	conf, err := filepath.Abs("conf.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	for _, args := range [][]string{
+		{"keygen", "-self-signed", "-bits", "1024", "-out", "dev/PESAPAL", "-cn", "PESAPAL_DEV"},
+		{"keygen", "-self-signed", "-bits", "1024", "-out", "dev/NIDACIGSecurity", "-cn", "NIDA-CIG-DEV"},
+	} {
+		var stderr strings.Builder
+		if code := runNidactl(args, io.Discard, &stderr); code != 0 {
+			t.Fatalf("nida ctl %s: exit %d: %s", strings.Join(args, " "), code, stderr.String())
+		}
+	}
+
+	for _, profile := range []string{profileSandbox, profileSimulator} {
+		t.Run(profile, func(t *testing.T) {
+			src := &configSource{
+				file:      conf,
+				lookupEnv: func(string) (string, bool) { return "", false },
+				sets:      []string{"profile=" + profile},
+			}
+			cfg, err := LoadConfig(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := cfg.Keys.StakeholderKeys(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Profiles name the NIDA gateway a deployment talks to. staging uses NIDA's
+// test CIG and production its production CIG. sandbox and simulator are for
+// development against a local stand-in, with the self-signed keys that
+// make dev-keys creates. Only sandbox and simulator may run with insecure
+// settings.
+const (
+	profileSandbox    = "sandbox"
+	profileStaging    = "staging"
+	profileProduction = "production"
+	profileSimulator  = "simulator"
+)
+
+var knownProfiles = map[string]bool{
+	profileSandbox:    true,
+	profileStaging:    true,
+	profileProduction: true,
+	profileSimulator:  true,
+}
+
+// allowsInsecure reports whether profile is for development, where the
+// insecure settings listed by rawConfig.insecure are accepted.
+func allowsInsecure(profile string) bool {
+	return profile == profileSandbox || profile == profileSimulator
+}
+
+// GatewayProfile holds the settings that differ between NIDA gateways:
+// the URL, our user ID there, the key material and the crypto suite, so
+// that a test gateway can move to new algorithms first. The section of the
+// active profile overrides the shared settings at the top of the file.
+type GatewayProfile struct {
+	NidaURL     string      `json:"nida_url"`
+	UserID      string      `json:"user_id"`
+	CryptoSuite CryptoSuite `json:"crypto_suite"`
+	keyConfig
+}
+
+// applyProfile copies the gateway settings of the selected profile over the
+// shared settings in tree. The profile is taken from overrides, the
+// environment and flags, before the file.
+func applyProfile(tree, overrides map[string]any) {
+	profile, _ := overrides["profile"].(string)
+	if profile == "" {
+		profile, _ = tree["profile"].(string)
+	}
+
+	sections, _ := tree["profiles"].(map[string]any)
+	section, _ := sections[profile].(map[string]any)
+	mergeTree(tree, section)
+}
+
+// mergeTree copies src over dst, merging objects key by key. Objects are
+// copied rather than shared, so later merges into dst leave src intact.
+func mergeTree(dst, src map[string]any) {
+	for key, value := range src {
+		if srcObj, ok := value.(map[string]any); ok {
+			dstObj, ok := dst[key].(map[string]any)
+			if !ok {
+				dstObj = map[string]any{}
+				dst[key] = dstObj
+			}
+			mergeTree(dstObj, srcObj)
+			continue
+		}
+		dst[key] = value
+	}
+}
+
+// validateProfile checks that a known profile is selected, that it has
+// gateway settings, and that they do not cross between the production
+// gateway and the others.
+func (rc rawConfig) validateProfile() error {
+	if rc.Profile == "" {
+		return fmt.Errorf("no profile selected: set NIDA_PROFILE to one of %s", profileNames())
+	}
+	for name := range rc.Profiles {
+		if !knownProfiles[name] {
+			return fmt.Errorf("profiles: unknown profile %q, expected one of %s", name, profileNames())
+		}
+	}
+	if !knownProfiles[rc.Profile] {
+		return fmt.Errorf("unknown profile %q, expected one of %s", rc.Profile, profileNames())
+	}
+	if _, ok := rc.Profiles[rc.Profile]; len(rc.Profiles) > 0 && !ok {
+		return fmt.Errorf("profile %s has no section under profiles", rc.Profile)
+	}
+	if rc.NidaURL == "" {
+		return fmt.Errorf("profile %s: nida_url is not set", rc.Profile)
+	}
+
+	for name, gp := range rc.Profiles {
+		if name == rc.Profile || gp.NidaURL == "" {
+			continue
+		}
+		if (name == profileProduction || rc.Profile == profileProduction) && sameURL(gp.NidaURL, rc.NidaURL) {
+			return fmt.Errorf("profile %s: nida_url %s is the %s gateway", rc.Profile, rc.NidaURL, name)
+		}
+	}
+
+	return nil
+}
+
+// sameURL compares gateway URLs, ignoring case and a trailing slash.
+func sameURL(a, b string) bool {
+	return strings.EqualFold(strings.TrimSuffix(a, "/"), strings.TrimSuffix(b, "/"))
+}
+
+func profileNames() string {
+	names := make([]string, 0, len(knownProfiles))
+	for name := range knownProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateProfile(t *testing.T) {
+	const (
+		productionURL = "https://cig.example/GatewayService.svc"
+		stagingURL    = "https://test-cig.example/GatewayService.svc"
+	)
+	profiles := map[string]GatewayProfile{
+		profileProduction: {NidaURL: productionURL},
+		profileStaging:    {NidaURL: stagingURL},
+		profileSandbox:    {},
+	}
+
+	tests := []struct {
+		name    string
+		profile string
+		nidaURL string
+		wantErr string
+	}{
+		{name: "production", profile: profileProduction, nidaURL: productionURL},
+		{name: "staging", profile: profileStaging, nidaURL: stagingURL},
+		{name: "staging on the production gateway", profile: profileStaging, nidaURL: productionURL, wantErr: "is the production gateway"},
+		{name: "case and trailing slash are ignored", profile: profileSandbox, nidaURL: "HTTPS://CIG.EXAMPLE/GatewayService.svc/", wantErr: "is the production gateway"},
+		{name: "production on the staging gateway", profile: profileProduction, nidaURL: stagingURL + "/", wantErr: "is the staging gateway"},
+		{name: "no gateway", profile: profileStaging, wantErr: "nida_url is not set"},
+		{name: "no profile", wantErr: "no profile selected"},
+		{name: "unknown profile", profile: "prod", nidaURL: productionURL, wantErr: `unknown profile "prod"`},
+		{name: "profile without a section", profile: profileSimulator, nidaURL: "http://localhost:8090/GatewayService.svc", wantErr: "has no section under profiles"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := rawConfig{Profile: tt.profile, Profiles: profiles, NidaURL: tt.nidaURL}
+			err := rc.validateProfile()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validateProfile = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validateProfile = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimit is a token bucket: RPS requests per second on average, with
+// bursts of up to Burst. A zero RPS means no limit.
+type RateLimit struct {
+	RPS   float64 `json:"rps"`
+	Burst int     `json:"burst"`
+}
+
+// RateLimitConfig caps requests to the gateway, as the stakeholder agreement
+// requires. A request has to fit both the global and its operation's limit.
+type RateLimitConfig struct {
+	Global     RateLimit            `json:"global"`
+	Operations map[string]RateLimit `json:"operations"`
+}
+
+func (rl RateLimit) validate(name string) error {
+	if rl.RPS < 0 || rl.Burst < 0 {
+		return fmt.Errorf("rate limit %s: rps and burst must not be negative", name)
+	}
+	return nil
+}
+
+func (rc RateLimitConfig) Validate() error {
+	if err := rc.Global.validate("global"); err != nil {
+		return err
+	}
+	for op, rl := range rc.Operations {
+		if !slices.Contains(gatewayOperations, op) && !slices.Contains(stakeholderOperations, op) {
+			return fmt.Errorf("rate limit: unknown gateway operation %q", op)
+		}
+		if err := rl.validate(op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rl RateLimit) limiter() *rate.Limiter {
+	if rl.RPS == 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(rl.RPS), max(rl.Burst, 1))
+}
+
+// errRateLimited is returned when the wait for a token would outlast the
+// caller's deadline.
+var errRateLimited = errors.New("gateway rate limit would exceed the request deadline")
+
+// gatewayLimiter holds the token buckets of a RateLimitConfig.
+type gatewayLimiter struct {
+	global     *rate.Limiter
+	operations map[string]*rate.Limiter
+}
+
+func newGatewayLimiter(rc RateLimitConfig) *gatewayLimiter {
+	l := &gatewayLimiter{
+		global:     rc.Global.limiter(),
+		operations: map[string]*rate.Limiter{},
+	}
+	for op, rl := range rc.Operations {
+		if lim := rl.limiter(); lim != nil {
+			l.operations[op] = lim
+		}
+	}
+	return l
+}
+
+// wait blocks until operation may be sent, or fails straight away when that
+// would be after ctx's deadline. Time spent waiting is recorded in
+// nida_rate_limit_wait_seconds.
+func (l *gatewayLimiter) wait(ctx context.Context, operation string) error {
+	start := time.Now()
+	defer func() {
+		rateLimitWaitSeconds.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	}()
+
+	for _, lim := range []*rate.Limiter{l.operations[operation], l.global} {
+		if lim == nil {
+			continue
+		}
+		if err := lim.Wait(ctx); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return fmt.Errorf("%w: %s", errRateLimited, operation)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// idempotentOperations may be retried: they only read from NIDA. Answering
+// a reverse question or asking for one counts against the NIN's attempts,
+// so those are never retried.
+var idempotentOperations = map[string]bool{
+	opDemographicLookup: true,
+	opPhotoRetrieval:    true,
+}
+
+// RetryConfig controls retries of idempotent gateway operations.
+type RetryConfig struct {
+	// MaxAttempts counts the first try; 1 disables retries.
+	MaxAttempts    int      `json:"max_attempts"`
+	InitialBackoff Duration `json:"initial_backoff"`
+	MaxBackoff     Duration `json:"max_backoff"`
+}
+
+var defaultRetryConfig = RetryConfig{
+	MaxAttempts:    3,
+	InitialBackoff: Duration(200 * time.Millisecond),
+	MaxBackoff:     Duration(2 * time.Second),
+}
+
+func (rc RetryConfig) withDefaults() RetryConfig {
+	if rc.MaxAttempts == 0 {
+		rc.MaxAttempts = defaultRetryConfig.MaxAttempts
+	}
+	if rc.InitialBackoff == 0 {
+		rc.InitialBackoff = defaultRetryConfig.InitialBackoff
+	}
+	if rc.MaxBackoff == 0 {
+		rc.MaxBackoff = defaultRetryConfig.MaxBackoff
+	}
+	return rc
+}
+
+func (rc RetryConfig) Validate() error {
+	if rc.MaxAttempts < 1 {
+		return fmt.Errorf("retry max_attempts must be at least 1")
+	}
+	if rc.InitialBackoff <= 0 || rc.MaxBackoff < rc.InitialBackoff {
+		return fmt.Errorf("retry needs 0 < initial_backoff <= max_backoff")
+	}
+	return nil
+}
+
+// backoff returns the wait before retry number attempt (from 1), drawn
+// uniformly up to an exponentially growing cap ("full jitter").
+func (rc RetryConfig) backoff(attempt int) time.Duration {
+	ceiling := time.Duration(rc.MaxBackoff)
+	if shift := attempt - 1; shift < 32 {
+		ceiling = min(ceiling, time.Duration(rc.InitialBackoff)<<shift)
+	}
+	return time.Duration(rand.Int64N(int64(ceiling) + 1))
+}
+
+// BreakerConfig controls the circuit breaker in front of the gateway.
+type BreakerConfig struct {
+	// FailureThreshold consecutive failures open the breaker.
+	FailureThreshold int `json:"failure_threshold"`
+	// OpenFor is how long the breaker fails fast before letting a probe
+	// call through.
+	OpenFor Duration `json:"open_for"`
+}
+
+var defaultBreakerConfig = BreakerConfig{
+	FailureThreshold: 5,
+	OpenFor:          Duration(30 * time.Second),
+}
+
+func (bc BreakerConfig) withDefaults() BreakerConfig {
+	if bc.FailureThreshold == 0 {
+		bc.FailureThreshold = defaultBreakerConfig.FailureThreshold
+	}
+	if bc.OpenFor == 0 {
+		bc.OpenFor = defaultBreakerConfig.OpenFor
+	}
+	return bc
+}
+
+func (bc BreakerConfig) Validate() error {
+	if bc.FailureThreshold < 1 || bc.OpenFor <= 0 {
+		return fmt.Errorf("circuit breaker needs failure_threshold >= 1 and a positive open_for")
+	}
+	return nil
+}
+
+// CircuitOpenError is returned without calling the gateway while the
+// breaker is open.
+type CircuitOpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("gateway circuit open, retry after %s", e.RetryAfter.Round(time.Second))
+}
+
+// GatewayStatusError is a non-200 response that carried no SOAP fault.
+type GatewayStatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *GatewayStatusError) Error() string {
+	return "gateway returned " + e.Status
+}
+
+// isGatewayFailure reports whether err says the gateway is unhealthy: a
+// timeout, a refused or reset connection, or a 502, 503 or 504. Only these
+// are retried and counted by the breaker. Faults, other statuses, TLS and
+// request errors, and the caller's ctx ending are not.
+func isGatewayFailure(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+
+	var statusErr *GatewayStatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitBreaker opens after FailureThreshold consecutive gateway failures.
+// Once OpenFor has passed a single probe is let through; its outcome closes
+// the breaker or opens it again.
+type circuitBreaker struct {
+	cfg BreakerConfig
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newCircuitBreaker(cfg BreakerConfig) *circuitBreaker {
+	return &circuitBreaker{cfg: cfg}
+}
+
+// allow returns a *CircuitOpenError if a call may not go ahead now.
+func (b *circuitBreaker) allow(now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen {
+		if wait := b.openedAt.Add(time.Duration(b.cfg.OpenFor)).Sub(now); wait > 0 {
+			return &CircuitOpenError{RetryAfter: wait}
+		}
+		b.state = breakerHalfOpen
+	}
+
+	if b.state == breakerHalfOpen {
+		if b.probing {
+			return &CircuitOpenError{RetryAfter: time.Second}
+		}
+		b.probing = true
+	}
+
+	return nil
+}
+
+// record notes the outcome of a call that allow let through. ctx is the
+// call's context.
+func (b *circuitBreaker) record(ctx context.Context, now time.Time, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerHalfOpen {
+		b.probing = false
+		// A probe the caller abandoned says nothing about the gateway.
+		if ctx.Err() != nil {
+			return
+		}
+	}
+
+	if !isGatewayFailure(ctx, err) {
+		b.state, b.failures = breakerClosed, 0
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.cfg.FailureThreshold {
+		b.state, b.openedAt = breakerOpen, now
+	}
+}
+
+// sleepContext waits for d, returning early with ctx's error.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsGatewayFailure(t *testing.T) {
+	urlErr := func(err error) error {
+		return &url.Error{Op: "Post", URL: "https://gateway.example/svc", Err: err}
+	}
+	dialErr := func(errno syscall.Errno) error {
+		return urlErr(&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", errno)})
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"success", nil, false},
+		{"502", &GatewayStatusError{StatusCode: 502, Status: "502 Bad Gateway"}, true},
+		{"503", &GatewayStatusError{StatusCode: 503, Status: "503 Service Unavailable"}, true},
+		{"504", &GatewayStatusError{StatusCode: 504, Status: "504 Gateway Timeout"}, true},
+		{"500", &GatewayStatusError{StatusCode: 500, Status: "500 Internal Server Error"}, false},
+		{"404", &GatewayStatusError{StatusCode: 404, Status: "404 Not Found"}, false},
+		{"timeout", urlErr(timeoutError{}), true},
+		{"connection refused", dialErr(syscall.ECONNREFUSED), true},
+		{"connection reset", dialErr(syscall.ECONNRESET), true},
+		{"untrusted certificate", urlErr(x509.UnknownAuthorityError{}), false},
+		{"malformed url", urlErr(errors.New("unsupported protocol scheme")), false},
+		{"soap fault", errors.New("soap fault: invalid NIN"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isGatewayFailure(context.Background(), tt.err); got != tt.want {
+				t.Fatalf("isGatewayFailure(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("caller deadline", func(t *testing.T) {
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancel()
+		if isGatewayFailure(ctx, urlErr(context.DeadlineExceeded)) {
+			t.Fatal("the caller's own deadline counted as a gateway failure")
+		}
+	})
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	cfg := BreakerConfig{FailureThreshold: 3, OpenFor: Duration(30 * time.Second)}
+	failure := &GatewayStatusError{StatusCode: 503, Status: "503 Service Unavailable"}
+	ctx := context.Background()
+	start := time.Unix(1_700_000_000, 0)
+
+	// trip opens a new breaker at start.
+	trip := func(t *testing.T) *circuitBreaker {
+		t.Helper()
+		b := newCircuitBreaker(cfg)
+		for i := 0; i < cfg.FailureThreshold; i++ {
+			if err := b.allow(start); err != nil {
+				t.Fatalf("call %d: allow = %v while closed", i+1, err)
+			}
+			b.record(ctx, start, failure)
+		}
+		if b.state != breakerOpen {
+			t.Fatalf("state = %d after %d failures, want open", b.state, cfg.FailureThreshold)
+		}
+		return b
+	}
+	afterOpen := start.Add(time.Duration(cfg.OpenFor))
+
+	t.Run("stays closed below the threshold", func(t *testing.T) {
+		b := newCircuitBreaker(cfg)
+		for i := 0; i < cfg.FailureThreshold-1; i++ {
+			b.record(ctx, start, failure)
+		}
+		if err := b.allow(start); err != nil {
+			t.Fatalf("allow = %v, want nil", err)
+		}
+	})
+
+	t.Run("success resets the count", func(t *testing.T) {
+		b := newCircuitBreaker(cfg)
+		for i := 0; i < cfg.FailureThreshold-1; i++ {
+			b.record(ctx, start, failure)
+		}
+		b.record(ctx, start, nil)
+		b.record(ctx, start, failure)
+		if b.state != breakerClosed {
+			t.Fatalf("state = %d, want closed", b.state)
+		}
+	})
+
+	t.Run("other errors do not count", func(t *testing.T) {
+		b := newCircuitBreaker(cfg)
+		for i := 0; i < cfg.FailureThreshold*2; i++ {
+			b.record(ctx, start, &url.Error{Op: "Post", URL: "https://gateway.example/svc", Err: x509.UnknownAuthorityError{}})
+		}
+		if b.state != breakerClosed {
+			t.Fatalf("state = %d, want closed", b.state)
+		}
+	})
+
+	t.Run("open fails fast", func(t *testing.T) {
+		b := trip(t)
+		var open *CircuitOpenError
+		if err := b.allow(start.Add(10 * time.Second)); !errors.As(err, &open) {
+			t.Fatalf("allow = %v, want *CircuitOpenError", err)
+		}
+		if open.RetryAfter != 20*time.Second {
+			t.Fatalf("RetryAfter = %s, want 20s", open.RetryAfter)
+		}
+	})
+
+	t.Run("half open lets one probe through", func(t *testing.T) {
+		b := trip(t)
+		if err := b.allow(afterOpen); err != nil {
+			t.Fatalf("probe: allow = %v, want nil", err)
+		}
+		if b.state != breakerHalfOpen {
+			t.Fatalf("state = %d, want half open", b.state)
+		}
+		var open *CircuitOpenError
+		if err := b.allow(afterOpen); !errors.As(err, &open) {
+			t.Fatalf("second call during probe: allow = %v, want *CircuitOpenError", err)
+		}
+	})
+
+	t.Run("successful probe closes", func(t *testing.T) {
+		b := trip(t)
+		b.allow(afterOpen)
+		b.record(ctx, afterOpen, nil)
+		if b.state != breakerClosed {
+			t.Fatalf("state = %d, want closed", b.state)
+		}
+		if err := b.allow(afterOpen); err != nil {
+			t.Fatalf("allow = %v, want nil", err)
+		}
+	})
+
+	t.Run("failed probe reopens", func(t *testing.T) {
+		b := trip(t)
+		b.allow(afterOpen)
+		b.record(ctx, afterOpen, failure)
+		if b.state != breakerOpen {
+			t.Fatalf("state = %d, want open", b.state)
+		}
+		if err := b.allow(afterOpen.Add(time.Second)); err == nil {
+			t.Fatal("allow = nil right after a failed probe")
+		}
+		if err := b.allow(afterOpen.Add(time.Duration(cfg.OpenFor))); err != nil {
+			t.Fatalf("allow = %v once open_for passed again, want nil", err)
+		}
+	})
+
+	t.Run("abandoned probe is ignored", func(t *testing.T) {
+		b := trip(t)
+		b.allow(afterOpen)
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		b.record(canceled, afterOpen, canceled.Err())
+		if b.state != breakerHalfOpen {
+			t.Fatalf("state = %d, want half open", b.state)
+		}
+		if err := b.allow(afterOpen); err != nil {
+			t.Fatalf("next probe: allow = %v, want nil", err)
+		}
+	})
+}
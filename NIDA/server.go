@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ServerConfig configures the API's HTTP server.
+type ServerConfig struct {
+	// Addr is the listen address, ":8080" by default. PORT overrides it.
+	Addr string `json:"addr"`
+	// PublicHost is the URL callers reach the service at.
+	PublicHost string `json:"public_host"`
+	// TrustedProxies are the IPs and CIDRs of the proxies whose
+	// X-Forwarded-For is believed when finding a client's IP. None are
+	// trusted by default, so the client IP is the peer address.
+	TrustedProxies    []string `json:"trusted_proxies"`
+	ReadHeaderTimeout Duration `json:"read_header_timeout"`
+	ReadTimeout       Duration `json:"read_timeout"`
+	// WriteTimeout bounds a whole request, so it has to allow for gateway
+	// calls and their retries.
+	WriteTimeout   Duration `json:"write_timeout"`
+	IdleTimeout    Duration `json:"idle_timeout"`
+	MaxHeaderBytes int      `json:"max_header_bytes"`
+	// TLSCert and TLSKey are a PEM certificate and key to serve HTTPS with.
+	// Without them the server speaks plain HTTP.
+	TLSCert string `json:"tls_cert"`
+	TLSKey  string `json:"tls_key"`
+	// ShutdownTimeout bounds how long shutdown waits for in-flight requests
+	// and background work. It should be shorter than the orchestrator's
+	// grace period.
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+}
+
+var defaultServerConfig = ServerConfig{
+	ReadHeaderTimeout: Duration(10 * time.Second),
+	ReadTimeout:       Duration(30 * time.Second),
+	WriteTimeout:      Duration(2 * time.Minute),
+	IdleTimeout:       Duration(2 * time.Minute),
+	MaxHeaderBytes:    http.DefaultMaxHeaderBytes,
+	ShutdownTimeout:   Duration(25 * time.Second),
+}
+
+func (sc ServerConfig) withDefaults() ServerConfig {
+	if sc.Addr == "" {
+		sc.Addr = ":8080"
+	}
+	if sc.PublicHost == "" {
+		sc.PublicHost = "http://localhost"
+	}
+	if sc.ReadHeaderTimeout == 0 {
+		sc.ReadHeaderTimeout = defaultServerConfig.ReadHeaderTimeout
+	}
+	if sc.ReadTimeout == 0 {
+		sc.ReadTimeout = defaultServerConfig.ReadTimeout
+	}
+	if sc.WriteTimeout == 0 {
+		sc.WriteTimeout = defaultServerConfig.WriteTimeout
+	}
+	if sc.IdleTimeout == 0 {
+		sc.IdleTimeout = defaultServerConfig.IdleTimeout
+	}
+	if sc.MaxHeaderBytes == 0 {
+		sc.MaxHeaderBytes = defaultServerConfig.MaxHeaderBytes
+	}
+	if sc.ShutdownTimeout == 0 {
+		sc.ShutdownTimeout = defaultServerConfig.ShutdownTimeout
+	}
+	return sc
+}
+
+func (sc ServerConfig) Validate() error {
+	if sc.ReadHeaderTimeout < 0 || sc.ReadTimeout < 0 || sc.WriteTimeout < 0 || sc.IdleTimeout < 0 || sc.ShutdownTimeout < 0 {
+		return fmt.Errorf("server timeouts must not be negative")
+	}
+	if sc.MaxHeaderBytes < 0 {
+		return fmt.Errorf("server max_header_bytes must not be negative")
+	}
+	if (sc.TLSCert == "") != (sc.TLSKey == "") {
+		return fmt.Errorf("server tls_cert and tls_key must be set together")
+	}
+	for _, proxy := range sc.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				return fmt.Errorf("server trusted_proxies: %q is not an IP or CIDR", proxy)
+			}
+		}
+	}
+	return nil
+}
+
+// serve runs handler until SIGINT or SIGTERM, then stops accepting
+// connections and waits up to the shutdown timeout for in-flight requests
+// and background work to finish. A second signal stops the process
+// straight away.
+func serve(sc ServerConfig, handler http.Handler, background *Background) error {
+	srv := &http.Server{
+		Addr:              sc.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: time.Duration(sc.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(sc.ReadTimeout),
+		WriteTimeout:      time.Duration(sc.WriteTimeout),
+		IdleTimeout:       time.Duration(sc.IdleTimeout),
+		MaxHeaderBytes:    sc.MaxHeaderBytes,
+		TLSConfig:         &tls.Config{MinVersion: tls.VersionTLS12},
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, 1)
+	go func() {
+		slog.Info("listening", "addr", sc.Addr, "tls", sc.TLSCert != "", "public_host", sc.PublicHost)
+		if sc.TLSCert != "" {
+			errs <- srv.ListenAndServeTLS(sc.TLSCert, sc.TLSKey)
+		} else {
+			errs <- srv.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	stop()
+
+	slog.Info("shutting down", "timeout", time.Duration(sc.ShutdownTimeout).String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(sc.ShutdownTimeout))
+	defer cancel()
+
+	err := srv.Shutdown(shutdownCtx)
+	if err != nil {
+		slog.Warn("requests still in flight at shutdown", "err", err)
+	}
+	if err := background.Wait(shutdownCtx); err != nil {
+		slog.Warn("background work still running at shutdown", "err", err)
+	}
+
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	slog.Info("shut down")
+	return nil
+}
+
+// Background runs work that outlives the request that started it, such as
+// sending emails, so that shutdown can wait for it.
+type Background struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewBackground() *Background {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Background{ctx: ctx, cancel: cancel}
+}
+
+// Go runs fn in a goroutine. fn's context keeps the values of ctx, such as
+// the request logger, but is only cancelled when shutdown gives up waiting.
+func (b *Background) Go(ctx context.Context, fn func(context.Context)) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(b.ctx, cancel)
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		defer cancel()
+		defer stop()
+		defer func() {
+			if r := recover(); r != nil {
+				loggerFrom(ctx).Error("background work panicked", "panic", fmt.Sprint(r))
+			}
+		}()
+
+		fn(ctx)
+	}()
+}
+
+// Wait waits for the work started with Go. If ctx is done first the work is
+// cancelled and Wait returns without waiting further.
+func (b *Background) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		b.cancel()
+		return ctx.Err()
+	}
+}
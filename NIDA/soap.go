@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+type SOAPVersion string
+
+const (
+	SOAP11 SOAPVersion = "1.1"
+	SOAP12 SOAPVersion = "1.2"
+)
+
+const (
+	soap11Namespace = "http://schemas.xmlsoap.org/soap/envelope/"
+	soap12Namespace = "http://www.w3.org/2003/05/soap-envelope"
+)
+
+// SOAPConfig describes how envelopes are framed for the gateway.
+type SOAPConfig struct {
+	Version SOAPVersion `json:"version"`
+	// Namespace is the service namespace of the header and body elements.
+	Namespace string `json:"namespace"`
+	// ActionBase is prefixed to the operation name to form the SOAP action.
+	ActionBase string `json:"action_base"`
+}
+
+// defaultSOAPConfig matches the WCF defaults GatewayService.svc is built with.
+var defaultSOAPConfig = SOAPConfig{
+	Version:    SOAP11,
+	Namespace:  "http://tempuri.org/",
+	ActionBase: "http://tempuri.org/IGatewayService/",
+}
+
+func (sc SOAPConfig) withDefaults() SOAPConfig {
+	if sc.Version == "" {
+		sc.Version = defaultSOAPConfig.Version
+	}
+	if sc.Namespace == "" {
+		sc.Namespace = defaultSOAPConfig.Namespace
+	}
+	if sc.ActionBase == "" {
+		sc.ActionBase = defaultSOAPConfig.ActionBase
+	}
+	return sc
+}
+
+func (sc SOAPConfig) Validate() error {
+	switch sc.Version {
+	case SOAP11, SOAP12:
+		return nil
+	default:
+		return fmt.Errorf("unsupported soap version %q", sc.Version)
+	}
+}
+
+func (v SOAPVersion) namespace() string {
+	if v == SOAP12 {
+		return soap12Namespace
+	}
+	return soap11Namespace
+}
+
+func (sc SOAPConfig) action(operation string) string {
+	return sc.ActionBase + operation
+}
+
+// setHeaders sets Content-Type and the SOAP action the way each version
+// expects: a SOAPAction header for 1.1, an action parameter for 1.2.
+func (sc SOAPConfig) setHeaders(h http.Header, operation string) {
+	action := sc.action(operation)
+	if sc.Version == SOAP12 {
+		h.Set("Content-Type", fmt.Sprintf(`application/soap+xml; charset=utf-8; action="%s"`, action))
+		return
+	}
+
+	h.Set("Content-Type", "text/xml; charset=utf-8")
+	h.Set("SOAPAction", `"`+action+`"`)
+}
+
+// newSoapHTTPRequest builds a POST of an envelope for operation.
+func newSoapHTTPRequest(ctx context.Context, url string, sc SOAPConfig, operation string, envelope io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	sc.setHeaders(req.Header, operation)
+
+	return req, nil
+}
+
+// decodeSoapResponse decodes an envelope whatever prefix the gateway uses,
+// as long as it is in the SOAP 1.1 or 1.2 envelope namespace. A soap:Fault
+// in the body is returned as a *SOAPFault error.
+func decodeSoapResponse(r io.Reader) (SoapResponse, error) {
+	var resp SoapResponse
+	if err := xml.NewDecoder(r).Decode(&resp); err != nil {
+		return SoapResponse{}, fmt.Errorf("decode soap response: %w", err)
+	}
+
+	switch strings.TrimSpace(resp.XMLName.Space) {
+	case soap11Namespace, soap12Namespace:
+	default:
+		return SoapResponse{}, fmt.Errorf("decode soap response: unexpected envelope namespace %q", resp.XMLName.Space)
+	}
+
+	if resp.Body.Fault != nil {
+		return SoapResponse{}, resp.Body.Fault.fault()
+	}
+
+	return resp, nil
+}
+
+// SOAPFault is a soap:Fault returned by the gateway, in either SOAP 1.1 or
+// 1.2 form.
+type SOAPFault struct {
+	Code   string
+	String string
+	Actor  string
+	Detail string
+}
+
+func (f *SOAPFault) Error() string {
+	return fmt.Sprintf("soap fault %s: %s", f.Code, f.String)
+}
+
+// rawSOAPFault decodes both fault layouts; SOAP 1.1 uses the lower case
+// elements, SOAP 1.2 the Code/Reason/Detail ones.
+type rawSOAPFault struct {
+	FaultCode   string `xml:"faultcode"`
+	FaultString string `xml:"faultstring"`
+	FaultActor  string `xml:"faultactor"`
+	Detail11    struct {
+		Inner string `xml:",innerxml"`
+	} `xml:"detail"`
+
+	Code struct {
+		Value string `xml:"Value"`
+	} `xml:"Code"`
+	Reason struct {
+		Text string `xml:"Text"`
+	} `xml:"Reason"`
+	Role     string `xml:"Role"`
+	Detail12 struct {
+		Inner string `xml:",innerxml"`
+	} `xml:"Detail"`
+}
+
+func (rf *rawSOAPFault) fault() *SOAPFault {
+	f := &SOAPFault{
+		Code:   rf.FaultCode,
+		String: rf.FaultString,
+		Actor:  rf.FaultActor,
+		Detail: strings.TrimSpace(rf.Detail11.Inner),
+	}
+	if f.Code == "" {
+		f.Code = rf.Code.Value
+	}
+	if f.String == "" {
+		f.String = rf.Reason.Text
+	}
+	if f.Actor == "" {
+		f.Actor = rf.Role
+	}
+	if f.Detail == "" {
+		f.Detail = strings.TrimSpace(rf.Detail12.Inner)
+	}
+
+	return f
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSOAPEnvelopeRoundTrip(t *testing.T) {
+	key := testRSAKey(t)
+	keys, err := NewReloadingKeyProvider(func() (KeyProvider, error) {
+		return &staticKeyProvider{
+			privKeys: []StakeholderKey{{ID: "stakeholder", Key: key}},
+			pubKeys:  []MessageSecurityKey{{ID: "nida", Key: &key.PublicKey}},
+		}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := &RQVerificationRequest{NIN: "19900101123450000123"}
+
+	for _, version := range []SOAPVersion{SOAP11, SOAP12} {
+		t.Run(string(version), func(t *testing.T) {
+			cfg := &Config{
+				Suite:  CryptoSuite{}.withDefaults(),
+				Keys:   keys,
+				SOAP:   SOAPConfig{Version: version}.withDefaults(),
+				UserID: "PESAPAL_TEST",
+			}
+
+			req, err := newSoapRequest(context.Background(), cfg, "test", payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bs, err := xml.Marshal(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, want := range []string{
+				`<soap:Envelope xmlns:soap="` + version.namespace() + `">`,
+				`<soap:Header xmlns="http://tempuri.org/">`,
+				`<UserID>PESAPAL_TEST</UserID>`,
+				`<soap:Body xmlns="http://tempuri.org/"><CryptoInfo>`,
+			} {
+				if !bytes.Contains(bs, []byte(want)) {
+					t.Errorf("envelope lacks %s:\n%s", want, bs)
+				}
+			}
+			if bytes.Contains(bs, []byte("Fault")) {
+				t.Errorf("request envelope has a fault:\n%s", bs)
+			}
+
+			// The gateway answers in the same framing, so our own envelope
+			// has to decode and decrypt back to the payload.
+			resp, err := decodeSoapResponse(bytes.NewReader(bs))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Header.UserID != cfg.UserID {
+				t.Errorf("decoded UserID = %q, want %q", resp.Header.UserID, cfg.UserID)
+			}
+			got, err := resp.Payload(context.Background(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, _ := xml.Marshal(payload)
+			if !bytes.Equal(got, want) {
+				t.Fatalf("Payload = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeSoapResponseRejectsOtherNamespaces(t *testing.T) {
+	_, err := decodeSoapResponse(strings.NewReader(`<Envelope xmlns="urn:not-soap"><Body/></Envelope>`))
+	if err == nil || !strings.Contains(err.Error(), "unexpected envelope namespace") {
+		t.Fatalf("decodeSoapResponse = %v, want an envelope namespace error", err)
+	}
+}
+
+func TestSOAPHeaders(t *testing.T) {
+	const action = "http://tempuri.org/IGatewayService/RQVerification"
+
+	tests := []struct {
+		version         SOAPVersion
+		wantContentType string
+		wantSOAPAction  string
+	}{
+		{SOAP11, "text/xml; charset=utf-8", `"` + action + `"`},
+		{SOAP12, `application/soap+xml; charset=utf-8; action="` + action + `"`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.version), func(t *testing.T) {
+			sc := SOAPConfig{Version: tt.version}.withDefaults()
+			req, err := newSoapHTTPRequest(context.Background(), "http://localhost/GatewayService.svc", sc, opRQVerification, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.Method != http.MethodPost {
+				t.Errorf("method = %s, want %s", req.Method, http.MethodPost)
+			}
+			if got := req.Header.Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantContentType)
+			}
+			if got := req.Header.Get("SOAPAction"); got != tt.wantSOAPAction {
+				t.Errorf("SOAPAction = %q, want %q", got, tt.wantSOAPAction)
+			}
+		})
+	}
+}
+
+func TestDecodeSoapFault(t *testing.T) {
+	tests := []struct {
+		name     string
+		envelope string
+		want     SOAPFault
+	}{
+		{
+			name: "soap 1.1",
+			envelope: `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault>
+				<faultcode>s:Client</faultcode>
+				<faultstring xml:lang="en-US">Invalid signature</faultstring>
+				<faultactor>http://gateway/</faultactor>
+				<detail><Reason>payload</Reason></detail>
+			</s:Fault></s:Body></s:Envelope>`,
+			want: SOAPFault{Code: "s:Client", String: "Invalid signature", Actor: "http://gateway/", Detail: "<Reason>payload</Reason>"},
+		},
+		{
+			name: "soap 1.2",
+			envelope: `<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope"><env:Body><env:Fault>
+				<env:Code><env:Value>env:Receiver</env:Value></env:Code>
+				<env:Reason><env:Text xml:lang="en">Service unavailable</env:Text></env:Reason>
+				<env:Role>http://gateway/</env:Role>
+				<env:Detail><Retry>later</Retry></env:Detail>
+			</env:Fault></env:Body></env:Envelope>`,
+			want: SOAPFault{Code: "env:Receiver", String: "Service unavailable", Actor: "http://gateway/", Detail: "<Retry>later</Retry>"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeSoapResponse(strings.NewReader(tt.envelope))
+			var fault *SOAPFault
+			if !errors.As(err, &fault) {
+				t.Fatalf("decodeSoapResponse = %v, want a *SOAPFault", err)
+			}
+			if *fault != tt.want {
+				t.Fatalf("fault = %+v, want %+v", *fault, tt.want)
+			}
+		})
+	}
+}
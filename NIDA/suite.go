@@ -0,0 +1,297 @@
+package main
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+)
+
+type KeyWrap string
+
+const (
+	KeyWrapPKCS1v15   KeyWrap = "pkcs1v15"
+	KeyWrapOAEPSHA1   KeyWrap = "oaep-sha1"
+	KeyWrapOAEPSHA256 KeyWrap = "oaep-sha256"
+)
+
+type SymmetricCipher string
+
+const (
+	CipherAESCBC SymmetricCipher = "aes-cbc"
+	CipherAESGCM SymmetricCipher = "aes-gcm"
+)
+
+type SignatureScheme string
+
+const (
+	SignatureSHA1PKCS1v15   SignatureScheme = "sha1-pkcs1v15"
+	SignatureSHA256PKCS1v15 SignatureScheme = "sha256-pkcs1v15"
+	SignatureSHA256PSS      SignatureScheme = "sha256-pss"
+)
+
+// IVMode says where the receiver of an envelope finds the IV.
+type IVMode string
+
+const (
+	// IVWrapped puts the IV in front of the ciphertext and sends it wrapped
+	// in CryptoInfo. The receiver decrypts with the wrapped IV and skips the
+	// prefix, which is what our gateway exchange has always done.
+	IVWrapped IVMode = "wrapped"
+	// IVPrefixed puts the IV in front of the ciphertext, and still sends it
+	// wrapped in CryptoInfo. The receiver takes the IV from the prefix.
+	IVPrefixed IVMode = "prefixed"
+	// IVInCryptoInfo sends the IV only wrapped in CryptoInfo; the payload
+	// is the bare ciphertext.
+	IVInCryptoInfo IVMode = "crypto_info"
+)
+
+// CryptoSuite selects the algorithms used to protect an envelope: how the
+// AES key and IV are wrapped, how the payload is encrypted and how it is
+// signed.
+type CryptoSuite struct {
+	KeyWrap   KeyWrap         `json:"key_wrap"`
+	Cipher    SymmetricCipher `json:"cipher"`
+	Signature SignatureScheme `json:"signature"`
+	IVMode    IVMode          `json:"iv_mode"`
+}
+
+// defaultGatewaySuite is what the CIG gateway has always used.
+var defaultGatewaySuite = CryptoSuite{
+	KeyWrap:   KeyWrapPKCS1v15,
+	Cipher:    CipherAESCBC,
+	Signature: SignatureSHA1PKCS1v15,
+	IVMode:    IVWrapped,
+}
+
+// legacyCryptoSuite is used by the /verify endpoint, whose clients wrap
+// keys with RSA-OAEP.
+var legacyCryptoSuite = CryptoSuite{
+	KeyWrap:   KeyWrapOAEPSHA256,
+	Cipher:    CipherAESCBC,
+	Signature: SignatureSHA1PKCS1v15,
+	IVMode:    IVWrapped,
+}
+
+// withDefaults fills unset algorithms from defaultGatewaySuite.
+func (s CryptoSuite) withDefaults() CryptoSuite {
+	if s.KeyWrap == "" {
+		s.KeyWrap = defaultGatewaySuite.KeyWrap
+	}
+	if s.Cipher == "" {
+		s.Cipher = defaultGatewaySuite.Cipher
+	}
+	if s.Signature == "" {
+		s.Signature = defaultGatewaySuite.Signature
+	}
+	if s.IVMode == "" {
+		s.IVMode = defaultGatewaySuite.IVMode
+	}
+	return s
+}
+
+func (s CryptoSuite) Validate() error {
+	switch s.KeyWrap {
+	case KeyWrapPKCS1v15, KeyWrapOAEPSHA1, KeyWrapOAEPSHA256:
+	default:
+		return fmt.Errorf("unsupported key wrap %q", s.KeyWrap)
+	}
+
+	switch s.Cipher {
+	case CipherAESCBC, CipherAESGCM:
+	default:
+		return fmt.Errorf("unsupported cipher %q", s.Cipher)
+	}
+
+	switch s.Signature {
+	case SignatureSHA1PKCS1v15, SignatureSHA256PKCS1v15, SignatureSHA256PSS:
+	default:
+		return fmt.Errorf("unsupported signature scheme %q", s.Signature)
+	}
+
+	switch s.IVMode {
+	case IVWrapped, IVPrefixed, IVInCryptoInfo:
+	default:
+		return fmt.Errorf("unsupported iv mode %q", s.IVMode)
+	}
+
+	return nil
+}
+
+func (s CryptoSuite) oaepHash() hash.Hash {
+	if s.KeyWrap == KeyWrapOAEPSHA1 {
+		return sha1.New()
+	}
+	return sha256.New()
+}
+
+func (s CryptoSuite) WrapKey(publicKey *rsa.PublicKey, key []byte) ([]byte, error) {
+	switch s.KeyWrap {
+	case KeyWrapPKCS1v15:
+		return rsa.EncryptPKCS1v15(rand.Reader, publicKey, key)
+	case KeyWrapOAEPSHA1, KeyWrapOAEPSHA256:
+		return rsa.EncryptOAEP(s.oaepHash(), rand.Reader, publicKey, key, nil)
+	default:
+		return nil, fmt.Errorf("unsupported key wrap %q", s.KeyWrap)
+	}
+}
+
+func (s CryptoSuite) UnwrapKey(privateKey *rsa.PrivateKey, wrapped []byte) ([]byte, error) {
+	switch s.KeyWrap {
+	case KeyWrapPKCS1v15:
+		return rsa.DecryptPKCS1v15(nil, privateKey, wrapped)
+	case KeyWrapOAEPSHA1, KeyWrapOAEPSHA256:
+		return rsa.DecryptOAEP(s.oaepHash(), rand.Reader, privateKey, wrapped, nil)
+	default:
+		return nil, fmt.Errorf("unsupported key wrap %q", s.KeyWrap)
+	}
+}
+
+// IVSize is the length of the IV, or nonce for GCM, the cipher expects.
+func (s CryptoSuite) IVSize() int {
+	if s.Cipher == CipherAESGCM {
+		return 12
+	}
+	return aes.BlockSize
+}
+
+// prefixesIV reports whether the ciphertext starts with the IV.
+func (s CryptoSuite) prefixesIV() bool {
+	return s.IVMode == IVWrapped || s.IVMode == IVPrefixed
+}
+
+// Encrypt returns the encrypted payload, preceded by the IV in the
+// IVWrapped and IVPrefixed modes.
+func (s CryptoSuite) Encrypt(payload, aesKey, aesIV []byte) ([]byte, error) {
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(aesIV) != s.IVSize() {
+		return nil, fmt.Errorf("expected %d byte IV but got %d", s.IVSize(), len(aesIV))
+	}
+
+	var prefix []byte
+	if s.prefixesIV() {
+		prefix = aesIV
+	}
+
+	switch s.Cipher {
+	case CipherAESCBC:
+		paddedPayload := pad(payload, aes.BlockSize)
+		ciphertext := make([]byte, len(prefix)+len(paddedPayload))
+		copy(ciphertext, prefix)
+
+		mode := cipher.NewCBCEncrypter(block, aesIV)
+		mode.CryptBlocks(ciphertext[len(prefix):], paddedPayload)
+
+		return ciphertext, nil
+	case CipherAESGCM:
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		ciphertext := make([]byte, len(prefix), len(prefix)+len(payload)+gcm.Overhead())
+		copy(ciphertext, prefix)
+
+		return gcm.Seal(ciphertext, aesIV, payload, nil), nil
+	default:
+		return nil, fmt.Errorf("unsupported cipher %q", s.Cipher)
+	}
+}
+
+// Decrypt reverses Encrypt. In IVPrefixed mode the IV is read from the
+// ciphertext and aesIV is ignored; in IVWrapped mode the prefix is skipped
+// and aesIV used. Every malformed input yields errDecrypt.
+func (s CryptoSuite) Decrypt(ciphertext, aesKey, aesIV []byte) ([]byte, error) {
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, errDecrypt
+	}
+
+	if s.prefixesIV() {
+		if len(ciphertext) < s.IVSize() {
+			return nil, errDecrypt
+		}
+		prefix := ciphertext[:s.IVSize()]
+		ciphertext = ciphertext[s.IVSize():]
+		if s.IVMode == IVPrefixed {
+			aesIV = prefix
+		}
+	}
+
+	if len(aesIV) != s.IVSize() {
+		return nil, errDecrypt
+	}
+
+	switch s.Cipher {
+	case CipherAESCBC:
+		// At least one block is needed
+		if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+			return nil, errDecrypt
+		}
+
+		// Decrypt the payload into a fresh buffer, leaving the caller's intact
+		plaintext := make([]byte, len(ciphertext))
+		mode := cipher.NewCBCDecrypter(block, aesIV)
+		mode.CryptBlocks(plaintext, ciphertext)
+
+		return unpad(plaintext, aes.BlockSize)
+	case CipherAESGCM:
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, errDecrypt
+		}
+
+		plaintext, err := gcm.Open(nil, aesIV, ciphertext, nil)
+		if err != nil {
+			return nil, errDecrypt
+		}
+
+		return plaintext, nil
+	default:
+		return nil, errDecrypt
+	}
+}
+
+func (s CryptoSuite) signatureHash() crypto.Hash {
+	if s.Signature == SignatureSHA1PKCS1v15 {
+		return crypto.SHA1
+	}
+	return crypto.SHA256
+}
+
+func (s CryptoSuite) digest(payload []byte) []byte {
+	h := s.signatureHash().New()
+	h.Write(payload)
+	return h.Sum(nil)
+}
+
+func (s CryptoSuite) Sign(privateKey *rsa.PrivateKey, payload []byte) ([]byte, error) {
+	switch s.Signature {
+	case SignatureSHA1PKCS1v15, SignatureSHA256PKCS1v15:
+		return rsa.SignPKCS1v15(rand.Reader, privateKey, s.signatureHash(), s.digest(payload))
+	case SignatureSHA256PSS:
+		return rsa.SignPSS(rand.Reader, privateKey, s.signatureHash(), s.digest(payload), nil)
+	default:
+		return nil, fmt.Errorf("unsupported signature scheme %q", s.Signature)
+	}
+}
+
+func (s CryptoSuite) Verify(publicKey *rsa.PublicKey, payload, signature []byte) error {
+	switch s.Signature {
+	case SignatureSHA1PKCS1v15, SignatureSHA256PKCS1v15:
+		return rsa.VerifyPKCS1v15(publicKey, s.signatureHash(), s.digest(payload), signature)
+	case SignatureSHA256PSS:
+		return rsa.VerifyPSS(publicKey, s.signatureHash(), s.digest(payload), signature, nil)
+	default:
+		return fmt.Errorf("unsupported signature scheme %q", s.Signature)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCryptoSuiteRoundTrip(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := []byte("<Payload><NIN>19900101123450000123</NIN></Payload>")
+
+	for _, keyWrap := range []KeyWrap{KeyWrapPKCS1v15, KeyWrapOAEPSHA1, KeyWrapOAEPSHA256} {
+		for _, cipher := range []SymmetricCipher{CipherAESCBC, CipherAESGCM} {
+			for _, signature := range []SignatureScheme{SignatureSHA1PKCS1v15, SignatureSHA256PKCS1v15, SignatureSHA256PSS} {
+				for _, ivMode := range []IVMode{IVWrapped, IVPrefixed, IVInCryptoInfo} {
+					suite := CryptoSuite{KeyWrap: keyWrap, Cipher: cipher, Signature: signature, IVMode: ivMode}
+					t.Run(fmt.Sprintf("%s/%s/%s/%s", keyWrap, cipher, signature, ivMode), func(t *testing.T) {
+						if err := suite.Validate(); err != nil {
+							t.Fatal(err)
+						}
+						testRoundTrip(t, suite, privateKey, payload)
+					})
+				}
+			}
+		}
+	}
+}
+
+func testRoundTrip(t *testing.T, suite CryptoSuite, privateKey *rsa.PrivateKey, payload []byte) {
+	aesKey := make([]byte, 32)
+	aesIV := make([]byte, suite.IVSize())
+	rand.Read(aesKey)
+	rand.Read(aesIV)
+
+	wrappedKey, err := suite.WrapKey(&privateKey.PublicKey, aesKey)
+	if err != nil {
+		t.Fatalf("WrapKey: %v", err)
+	}
+	unwrappedKey, err := suite.UnwrapKey(privateKey, wrappedKey)
+	if err != nil {
+		t.Fatalf("UnwrapKey: %v", err)
+	}
+	if !bytes.Equal(unwrappedKey, aesKey) {
+		t.Fatalf("UnwrapKey = %x, want %x", unwrappedKey, aesKey)
+	}
+
+	ciphertext, err := suite.Encrypt(payload, aesKey, aesIV)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	plaintext, err := suite.Decrypt(ciphertext, unwrappedKey, aesIV)
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if !bytes.Equal(plaintext, payload) {
+		t.Fatalf("Decrypt = %q, want %q", plaintext, payload)
+	}
+
+	signature, err := suite.Sign(privateKey, payload)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if err := suite.Verify(&privateKey.PublicKey, payload, signature); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if err := suite.Verify(&privateKey.PublicKey, append(bytes.Clone(payload), ' '), signature); err == nil {
+		t.Fatal("Verify accepted a signature over a different payload")
+	}
+}
+
+func TestProfileCryptoSuite(t *testing.T) {
+	src := &configSource{
+		file: writeTestConfig(t, `{
+			"crypto_suite": {"key_wrap": "oaep-sha1"},
+			"profiles": {
+				"sandbox": {"nida_url": "https://test.example/gw", "crypto_suite": {"signature": "sha256-pkcs1v15"}},
+				"production": {"nida_url": "https://prod.example/gw"}
+			}
+		}`),
+		lookupEnv: func(string) (string, bool) { return "", false },
+	}
+
+	tests := []struct {
+		profile string
+		want    CryptoSuite
+	}{
+		{profileSandbox, CryptoSuite{KeyWrap: KeyWrapOAEPSHA1, Cipher: CipherAESCBC, Signature: SignatureSHA256PKCS1v15, IVMode: defaultGatewaySuite.IVMode}},
+		{profileProduction, CryptoSuite{KeyWrap: KeyWrapOAEPSHA1, Cipher: CipherAESCBC, Signature: SignatureSHA1PKCS1v15, IVMode: defaultGatewaySuite.IVMode}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.profile, func(t *testing.T) {
+			src.sets = []string{"profile=" + tt.profile}
+			rc, err := src.load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rc.CryptoSuite != tt.want {
+				t.Fatalf("crypto suite = %+v, want %+v", rc.CryptoSuite, tt.want)
+			}
+		})
+	}
+}
+
+func writeTestConfig(t *testing.T, config string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "conf.json")
+	if err := os.WriteFile(file, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// The IV mode vectors pin where each mode takes the IV from. The ciphertext
+// was produced independently with
+//
+//	openssl enc -aes-256-cbc -K 000102…1f -iv a0a1…af
+//
+// IVWrapped reproduces how responses were decrypted before iv_mode existed:
+// the IV prefix is skipped and the IV wrapped in CryptoInfo is used.
+func TestIVModeVectors(t *testing.T) {
+	key := mustHex(t, "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	iv := mustHex(t, "a0a1a2a3a4a5a6a7a8a9aaabacadaeaf")
+	otherIV := bytes.Repeat([]byte{0xff}, 16)
+	ciphertext := mustHex(t, "4cf81224c8530526d3992084ea8ef6297dc9bebd22fa196b5106e18d7449f435"+
+		"6707116f95e8c5cbc85fc242ebcd34d7544adeebcec8c91b537048b1d5c4e3b0")
+	plaintext := []byte("<Payload><NIN>19900101123450000123</NIN></Payload>")
+
+	concat := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+	tests := []struct {
+		name       string
+		mode       IVMode
+		ciphertext []byte
+		wrappedIV  []byte
+		want       bool
+	}{
+		{"wrapped uses the wrapped IV", IVWrapped, concat(otherIV, ciphertext), iv, true},
+		{"wrapped ignores the prefix", IVWrapped, concat(iv, ciphertext), otherIV, false},
+		{"prefixed uses the prefix", IVPrefixed, concat(iv, ciphertext), otherIV, true},
+		{"prefixed ignores the wrapped IV", IVPrefixed, concat(otherIV, ciphertext), iv, false},
+		{"crypto_info has no prefix", IVInCryptoInfo, ciphertext, iv, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			suite := CryptoSuite{Cipher: CipherAESCBC, IVMode: tt.mode}
+			got, err := suite.Decrypt(tt.ciphertext, key, tt.wrappedIV)
+			if tt.want && (err != nil || !bytes.Equal(got, plaintext)) {
+				t.Fatalf("Decrypt = %q, %v; want %q", got, err, plaintext)
+			}
+			if !tt.want && bytes.Equal(got, plaintext) {
+				t.Fatalf("Decrypt used the IV it should ignore")
+			}
+		})
+	}
+
+	for mode, want := range map[IVMode][]byte{
+		IVWrapped:      concat(iv, ciphertext),
+		IVPrefixed:     concat(iv, ciphertext),
+		IVInCryptoInfo: ciphertext,
+	} {
+		got, err := CryptoSuite{Cipher: CipherAESCBC, IVMode: mode}.Encrypt(plaintext, key, iv)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("%s: Encrypt = %x, %v; want %x", mode, got, err, want)
+		}
+	}
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	bs, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bs
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracingExporterNone   = "none"
+	tracingExporterOTLP   = "otlp"
+	tracingExporterStdout = "stdout"
+)
+
+// TracingConfig configures OpenTelemetry tracing. Trace IDs are generated
+// and returned to callers whatever the exporter.
+type TracingConfig struct {
+	// Exporter is otlp, stdout or none, the default.
+	Exporter string `json:"exporter"`
+	// Endpoint is the host:port of an OTLP/HTTP collector. When empty the
+	// OTEL_EXPORTER_OTLP_ENDPOINT environment variable applies.
+	Endpoint string `json:"endpoint"`
+	// Insecure sends spans to the collector over plain HTTP.
+	Insecure bool `json:"insecure"`
+	// SampleRatio is the fraction of new traces exported, 1 by default.
+	// Traces started by a caller follow the caller's decision.
+	SampleRatio float64 `json:"sample_ratio"`
+}
+
+func (tc TracingConfig) withDefaults() TracingConfig {
+	if tc.Exporter == "" {
+		tc.Exporter = tracingExporterNone
+	}
+	if tc.SampleRatio == 0 {
+		tc.SampleRatio = 1
+	}
+	return tc
+}
+
+func (tc TracingConfig) Validate() error {
+	switch tc.Exporter {
+	case tracingExporterNone, tracingExporterOTLP, tracingExporterStdout:
+	default:
+		return fmt.Errorf("tracing: unknown exporter %q", tc.Exporter)
+	}
+	if tc.SampleRatio < 0 || tc.SampleRatio > 1 {
+		return fmt.Errorf("tracing: sample_ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// tracer is used for every span. It follows the provider set up by
+// setupTracing, even though it is created before.
+var tracer = otel.Tracer("NIDA")
+
+// setupTracing installs the global tracer provider and W3C trace context
+// propagation. The returned function flushes and stops the exporter.
+func setupTracing(ctx context.Context, tc TracingConfig) (func(context.Context) error, error) {
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName("NIDA")))
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(tc.SampleRatio))),
+	}
+
+	switch tc.Exporter {
+	case tracingExporterOTLP:
+		var otlpOpts []otlptracehttp.Option
+		if tc.Endpoint != "" {
+			otlpOpts = append(otlpOpts, otlptracehttp.WithEndpoint(tc.Endpoint))
+		}
+		if tc.Insecure {
+			otlpOpts = append(otlpOpts, otlptracehttp.WithInsecure())
+		}
+		exporter, err := otlptracehttp.New(ctx, otlpOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("tracing: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	case tracingExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, fmt.Errorf("tracing: %w", err)
+		}
+		opts = append(opts, sdktrace.WithSyncer(exporter))
+	}
+
+	provider := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return provider.Shutdown, nil
+}
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceStep runs fn in a span called name.
+func traceStep(ctx context.Context, name string, fn func() error) error {
+	_, span := tracer.Start(ctx, name)
+	err := fn()
+	endSpan(span, err)
+	return err
+}
+
+// startSoapSpan starts the span of one exchange with the gateway.
+func startSoapSpan(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "soap "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.RPCSystemKey.String("soap"), semconv.RPCMethod(operation)),
+	)
+}
+
+// startDBSpan starts the span of a query on the merchant store. Queries
+// only hold placeholders, so the statement is safe to record.
+func startDBSpan(ctx context.Context, operation, table, query string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, operation+" "+table,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemMySQL,
+			semconv.DBOperationName(operation),
+			semconv.DBCollectionName(table),
+			semconv.DBQueryText(query),
+		),
+	)
+}
+
+const traceIDHeader = "X-Trace-ID"
+
+// requestTracing starts a server span for each request, continuing the
+// caller's trace when it sent a traceparent header, and returns the trace ID
+// in X-Trace-ID.
+func requestTracing(c *gin.Context) {
+	ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+	route := c.FullPath()
+	name := c.Request.Method
+	if route != "" {
+		name += " " + route
+	}
+	ctx, span := tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(c.Request.Method),
+			semconv.HTTPRoute(route),
+			semconv.ClientAddress(c.ClientIP()),
+		),
+	)
+	defer span.End()
+
+	if sc := span.SpanContext(); sc.HasTraceID() {
+		c.Header(traceIDHeader, sc.TraceID().String())
+	}
+
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+
+	status := c.Writer.Status()
+	span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+}
@@ -0,0 +1,359 @@
+// Command wsdlgen generates Go payload types and NidaClient methods from the
+// checked-in copy of the gateway WSDL and the schema of the decrypted
+// payloads. It is run through go generate.
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// definitions is the part of the WSDL the generator reads: the operations
+// and their SOAP actions. The payload types come from the payload schema.
+type definitions struct {
+	PortTypes []struct {
+		Operations []struct {
+			Name          string `xml:"name,attr"`
+			Documentation string `xml:"documentation"`
+		} `xml:"operation"`
+	} `xml:"portType"`
+	Bindings []struct {
+		Operations []struct {
+			Name   string `xml:"name,attr"`
+			Action struct {
+				SOAPAction string `xml:"soapAction,attr"`
+			} `xml:"operation"`
+		} `xml:"operation"`
+	} `xml:"binding"`
+}
+
+type schema struct {
+	Elements     []element     `xml:"element"`
+	ComplexTypes []complexType `xml:"complexType"`
+}
+
+type element struct {
+	Name          string       `xml:"name,attr"`
+	Type          string       `xml:"type,attr"`
+	MinOccurs     string       `xml:"minOccurs,attr"`
+	MaxOccurs     string       `xml:"maxOccurs,attr"`
+	Documentation string       `xml:"annotation>documentation"`
+	ComplexType   *complexType `xml:"complexType"`
+	// Operation and Message, in the urn:pesapal:nida:wsdlgen namespace,
+	// say which payload a top level element is. Message is request or
+	// response.
+	Operation string `xml:"urn:pesapal:nida:wsdlgen operation,attr"`
+	Message   string `xml:"urn:pesapal:nida:wsdlgen message,attr"`
+}
+
+type complexType struct {
+	Name          string    `xml:"name,attr"`
+	Documentation string    `xml:"annotation>documentation"`
+	Elements      []element `xml:"sequence>element"`
+}
+
+var xsdTypes = map[string]string{
+	"string":       "string",
+	"int":          "int",
+	"long":         "int64",
+	"short":        "int16",
+	"boolean":      "bool",
+	"decimal":      "float64",
+	"double":       "float64",
+	"date":         "string",
+	"dateTime":     "time.Time",
+	"base64Binary": "EncodedBytes",
+}
+
+func main() {
+	wsdlFile := flag.String("wsdl", "wsdl/GatewayService.wsdl", "WSDL file to read the operations from")
+	payloadFile := flag.String("payloads", "wsdl/payloads.xsd", "schema file to read the payload types from")
+	out := flag.String("out", "gateway_gen.go", "Go file to write")
+	pkg := flag.String("package", "main", "package of the generated file")
+	flag.Parse()
+
+	var defs definitions
+	if err := readXML(*wsdlFile, &defs); err != nil {
+		log.Fatal(err)
+	}
+	var payloads schema
+	if err := readXML(*payloadFile, &payloads); err != nil {
+		log.Fatal(err)
+	}
+
+	src, err := generate(defs, payloads, *wsdlFile+" and "+*payloadFile, *pkg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := os.WriteFile(*out, src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func readXML(file string, v any) error {
+	bs, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if err := xml.Unmarshal(bs, v); err != nil {
+		return fmt.Errorf("parse %s: %w", file, err)
+	}
+	return nil
+}
+
+// localName strips the namespace prefix from a QName such as tns:Foo.
+func localName(qname string) string {
+	if i := strings.IndexByte(qname, ':'); i >= 0 {
+		return qname[i+1:]
+	}
+	return qname
+}
+
+func goName(name string) string {
+	r := []rune(name)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+type generator struct {
+	buf      bytes.Buffer
+	types    map[string]bool
+	usesTime bool
+}
+
+func (g *generator) printf(format string, args ...any) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// comment writes doc as the godoc comment of name: a sentence starting with
+// name, such as "// Photo holds the photo registered for a NIN.". Without
+// doc it writes fallback, if any.
+func (g *generator) comment(name, doc, fallback string) {
+	doc = strings.Join(strings.Fields(doc), " ")
+	if doc == "" {
+		doc = fallback
+	}
+	if doc == "" {
+		return
+	}
+
+	r := []rune(doc)
+	r[0] = unicode.ToLower(r[0])
+	g.printf("// %s %s\n", name, string(r))
+}
+
+// fieldType returns the Go type of e. Anonymous nested types become nested
+// struct types.
+func (g *generator) fieldType(e element) (string, error) {
+	var typ string
+	switch {
+	case strings.HasPrefix(e.Type, "xs:"):
+		t, ok := xsdTypes[localName(e.Type)]
+		if !ok {
+			return "", fmt.Errorf("element %s: unsupported type %s", e.Name, e.Type)
+		}
+		typ = t
+	case e.Type != "":
+		typ = goName(localName(e.Type))
+		if !g.types[typ] {
+			return "", fmt.Errorf("element %s: unknown type %s", e.Name, e.Type)
+		}
+	case e.ComplexType != nil:
+		fields, err := g.fields(e.ComplexType.Elements)
+		if err != nil {
+			return "", fmt.Errorf("element %s: %w", e.Name, err)
+		}
+		typ = "struct {\n" + fields + "}"
+	default:
+		return "", fmt.Errorf("element %s: no type", e.Name)
+	}
+
+	if typ == "time.Time" {
+		g.usesTime = true
+	}
+	if e.MaxOccurs != "" && e.MaxOccurs != "1" {
+		typ = "[]" + typ
+	}
+
+	return typ, nil
+}
+
+// fields returns the struct fields for elements, one per line.
+func (g *generator) fields(elements []element) (string, error) {
+	var b strings.Builder
+	for _, e := range elements {
+		typ, err := g.fieldType(e)
+		if err != nil {
+			return "", err
+		}
+
+		tag := e.Name
+		if e.MinOccurs == "0" {
+			tag += ",omitempty"
+		}
+		fmt.Fprintf(&b, "\t%s %s `xml:%q`\n", goName(e.Name), typ, tag)
+	}
+
+	return b.String(), nil
+}
+
+type operation struct {
+	name, doc         string
+	request, response *element
+}
+
+// operations pairs the operations of the WSDL with their payload elements.
+// Each operation needs both payloads, and every payload an operation.
+func operations(defs definitions, payloads []element) ([]operation, error) {
+	actions := map[string]string{}
+	for _, b := range defs.Bindings {
+		for _, op := range b.Operations {
+			actions[op.Name] = op.Action.SOAPAction
+		}
+	}
+
+	var ops []operation
+	index := map[string]int{}
+	for _, pt := range defs.PortTypes {
+		for _, op := range pt.Operations {
+			if !strings.HasSuffix(actions[op.Name], "/"+op.Name) {
+				return nil, fmt.Errorf("operation %s: soap action %q does not end in the operation name", op.Name, actions[op.Name])
+			}
+			index[op.Name] = len(ops)
+			ops = append(ops, operation{name: op.Name, doc: op.Documentation})
+		}
+	}
+
+	for i := range payloads {
+		e := &payloads[i]
+		j, ok := index[e.Operation]
+		if !ok {
+			return nil, fmt.Errorf("payload %s: operation %q is not in the WSDL", e.Name, e.Operation)
+		}
+		switch e.Message {
+		case "request":
+			ops[j].request = e
+		case "response":
+			ops[j].response = e
+		default:
+			return nil, fmt.Errorf("payload %s: message is %q, expected request or response", e.Name, e.Message)
+		}
+	}
+
+	for _, op := range ops {
+		if op.request == nil || op.response == nil {
+			return nil, fmt.Errorf("operation %s: needs a request and a response payload", op.name)
+		}
+	}
+
+	return ops, nil
+}
+
+func generate(defs definitions, payloads schema, source, pkg string) ([]byte, error) {
+	g := &generator{types: map[string]bool{}}
+
+	complexTypes := slices.Clone(payloads.ComplexTypes)
+	for _, ct := range complexTypes {
+		g.types[goName(ct.Name)] = true
+	}
+
+	ops, err := operations(defs, payloads.Elements)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(complexTypes, func(i, j int) bool { return complexTypes[i].Name < complexTypes[j].Name })
+	for _, ct := range complexTypes {
+		fields, err := g.fields(ct.Elements)
+		if err != nil {
+			return nil, fmt.Errorf("type %s: %w", ct.Name, err)
+		}
+		g.comment(goName(ct.Name), ct.Documentation, "")
+		g.printf("type %s struct {\n%s}\n\n", goName(ct.Name), fields)
+	}
+
+	// The payload types are named after their operation; XMLName keeps the
+	// element name used on the wire. Responses also carry the envelope data
+	// in gatewayResponse.
+	for _, op := range ops {
+		for _, p := range []struct {
+			e      *element
+			suffix string
+		}{{op.request, "Request"}, {op.response, "Response"}} {
+			name := op.name + p.suffix
+
+			g.comment(name, p.e.Documentation, "")
+			g.printf("type %s struct {\n", name)
+			g.printf("\tXMLName xml.Name `xml:%q`\n", p.e.Name)
+			switch {
+			case p.e.ComplexType != nil:
+				fields, err := g.fields(p.e.ComplexType.Elements)
+				if err != nil {
+					return nil, fmt.Errorf("payload %s: %w", p.e.Name, err)
+				}
+				g.buf.WriteString(fields)
+			case p.e.Type != "":
+				base := goName(localName(p.e.Type))
+				if !g.types[base] {
+					return nil, fmt.Errorf("payload %s: unknown type %s", p.e.Name, p.e.Type)
+				}
+				g.printf("\t%s\n", base)
+			}
+			if p.suffix == "Response" {
+				g.printf("\tgatewayResponse\n")
+			}
+			g.printf("}\n\n")
+		}
+	}
+
+	g.printf("// Gateway operations, used to form the SOAP action.\nconst (\n")
+	for _, op := range ops {
+		g.printf("\top%s = %q\n", op.name, op.name)
+	}
+	g.printf(")\n\n")
+
+	g.printf("// gatewayOperations lists every operation in the WSDL.\nvar gatewayOperations = []string{\n")
+	for _, op := range ops {
+		g.printf("\top%s,\n", op.name)
+	}
+	g.printf("}\n\n")
+
+	for _, op := range ops {
+		doc := op.doc
+		if doc == "" {
+			doc = op.request.Documentation
+		}
+		g.comment(op.name, doc, fmt.Sprintf("calls the %s gateway operation.", op.name))
+		g.printf("func (c *NidaClient) %s(ctx context.Context, req *%sRequest) (*%sResponse, error) {\n", op.name, op.name, op.name)
+		g.printf("\tvar resp %sResponse\n", op.name)
+		g.printf("\tif err := c.invoke(ctx, op%s, req, &resp); err != nil {\n\t\treturn nil, err\n\t}\n\n", op.name)
+		g.printf("\treturn &resp, nil\n}\n\n")
+	}
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Code generated by wsdlgen from %s. DO NOT EDIT.\n\n", source)
+	fmt.Fprintf(&src, "package %s\n\n", pkg)
+	src.WriteString("import (\n\t\"context\"\n\t\"encoding/xml\"\n")
+	if g.usesTime {
+		src.WriteString("\t\"time\"\n")
+	}
+	src.WriteString(")\n\n")
+	src.Write(g.buf.Bytes())
+
+	formatted, err := format.Source(src.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %w", err)
+	}
+
+	return formatted, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testWSDL = `<wsdl:definitions xmlns:wsdl="http://schemas.xmlsoap.org/wsdl/" xmlns:soap="http://schemas.xmlsoap.org/wsdl/soap/">
+  <wsdl:portType name="IGatewayService">
+    <wsdl:operation name="Lookup"/>
+  </wsdl:portType>
+  <wsdl:binding name="Binding">
+    <wsdl:operation name="Lookup">
+      <soap:operation soapAction="http://tempuri.org/IGatewayService/Lookup"/>
+    </wsdl:operation>
+  </wsdl:binding>
+</wsdl:definitions>`
+
+func parse[T any](t *testing.T, doc string) T {
+	t.Helper()
+	var v T
+	if err := xml.Unmarshal([]byte(doc), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestGenerateAnonymousNestedTypes(t *testing.T) {
+	payloads := parse[schema](t, `<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" xmlns:gw="urn:pesapal:nida:wsdlgen">
+  <xs:element name="LookupPayload" gw:operation="Lookup" gw:message="request">
+    <xs:annotation><xs:documentation>Looks up a NIN.</xs:documentation></xs:annotation>
+    <xs:complexType><xs:sequence>
+      <xs:element name="NIN" type="xs:string"/>
+      <xs:element name="Options" minOccurs="0">
+        <xs:complexType><xs:sequence>
+          <xs:element name="Since" type="xs:dateTime"/>
+          <xs:element name="Fields" type="xs:string" maxOccurs="unbounded"/>
+        </xs:sequence></xs:complexType>
+      </xs:element>
+    </xs:sequence></xs:complexType>
+  </xs:element>
+  <xs:element name="LookupResult" type="Record" gw:operation="Lookup" gw:message="response"/>
+  <xs:complexType name="Record">
+    <xs:annotation><xs:documentation>Holds a record.</xs:documentation></xs:annotation>
+    <xs:sequence><xs:element name="Code" type="xs:int"/></xs:sequence>
+  </xs:complexType>
+</xs:schema>`)
+
+	src, err := generate(parse[definitions](t, testWSDL), payloads, "test", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"// Record holds a record.\n",
+		"// LookupRequest looks up a NIN.\ntype LookupRequest struct {\n",
+		"XMLName xml.Name `xml:\"LookupPayload\"`",
+		"Options struct {\n\t\tSince  time.Time `xml:\"Since\"`\n\t\tFields []string  `xml:\"Fields\"`\n\t} `xml:\"Options,omitempty\"`",
+		"\"time\"",
+		"// Lookup looks up a NIN.\nfunc (c *NidaClient) Lookup(",
+	} {
+		if !bytes.Contains(src, []byte(want)) {
+			t.Errorf("generated code lacks %q:\n%s", want, src)
+		}
+	}
+	if bytes.Contains(src, []byte("// Lookup: ")) || bytes.Contains(src, []byte("calls the Lookup gateway operation")) {
+		t.Errorf("generated code has a non-godoc or stacked comment:\n%s", src)
+	}
+}
+
+func TestGenerateRejectsMismatchedPayloads(t *testing.T) {
+	tests := []struct {
+		name     string
+		payloads string
+		want     string
+	}{
+		{
+			name:     "missing response",
+			payloads: `<element name="LookupPayload" type="xs:string" gw:operation="Lookup" gw:message="request"/>`,
+			want:     "needs a request and a response payload",
+		},
+		{
+			name:     "unknown operation",
+			payloads: `<element name="OtherPayload" type="xs:string" gw:operation="Other" gw:message="request"/>`,
+			want:     `operation "Other" is not in the WSDL`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payloads := parse[schema](t, `<schema xmlns:gw="urn:pesapal:nida:wsdlgen">`+tt.payloads+`</schema>`)
+			_, err := generate(parse[definitions](t, testWSDL), payloads, "test", "main")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("generate = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestGatewayGenUpToDate fails when gateway_gen.go was not regenerated after
+// the WSDL or payload schema changed.
+func TestGatewayGenUpToDate(t *testing.T) {
+	var defs definitions
+	if err := readXML("../GatewayService.wsdl", &defs); err != nil {
+		t.Fatal(err)
+	}
+	var payloads schema
+	if err := readXML("../payloads.xsd", &payloads); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := generate(defs, payloads, "wsdl/GatewayService.wsdl and wsdl/payloads.xsd", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkedIn, err := os.ReadFile("../../gateway_gen.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, checkedIn) {
+		t.Fatal("gateway_gen.go is out of date, run go generate")
+	}
+}